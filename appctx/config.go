@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/skratchdot/open-golang/open"
 	"github.com/take0244/go-icloud-photo-gui/util"
@@ -16,15 +19,61 @@ type (
 		AppleId                    string
 	}
 	ConfigFile struct {
-		MaxParallel   int
-		OauthClientId string
-		AppleInfo     map[string]AppleInfo
+		MaxParallel          int
+		OauthClientId        string
+		AppleInfo            map[string]AppleInfo
+		ZipBatchBytes        int64
+		DirectThresholdBytes int64
+		Downloader           string
+		Retry                map[util.EndpointClass]RetryConfig
+		RateLimit            map[util.EndpointClass]util.RateLimit
+		Bandwidth            BandwidthConfig
+		Network              util.TransportOptions
+		PendingJob           *PendingJob
+		Schedule             ScheduleConfig
+	}
+	// IntervalかCronのどちらかを指定する、UserIDのcookieを使ってDirに差分をダウンロードする
+	ScheduleConfig struct {
+		Enabled  bool
+		UserID   string
+		Dir      string
+		Options  string
+		Interval string
+		Cron     string
+	}
+	// 終わっていないダウンロード、再起動後に続きから再開する
+	PendingJob struct {
+		Dir     string
+		Options string
+	}
+	// LimitBytesは秒間のバイト数で0なら無制限
+	BandwidthConfig struct {
+		LimitBytes  int64
+		UseSchedule bool
+		Schedule    []BandwidthWindow
+	}
+	// Start,Endは"15:04"形式、Endが前なら日をまたぐ
+	BandwidthWindow struct {
+		Start      string
+		End        string
+		LimitBytes int64
+	}
+	RetryConfig struct {
+		Attempts    int
+		BaseDelayMs int64
+		MaxDelayMs  int64
 	}
 )
 
 const (
 	README               = "# write app_config.json OauthClientId"
 	configKey contextKey = "config"
+
+	DownloaderGrab      = "grab"
+	DownloaderSegmented = "segmented"
+
+	defaultZipBatchBytes        = 1 << 30
+	defaultDirectThresholdBytes = 500 << 20
 )
 
 var (
@@ -33,21 +82,64 @@ var (
 	readmeFile                = "README.md"
 	confCache     *ConfigFile = nil
 	defaultConfig             = ConfigFile{
-		OauthClientId: "changeit",
-		MaxParallel:   3,
-		AppleInfo:     map[string]AppleInfo{},
+		OauthClientId:        "changeit",
+		MaxParallel:          3,
+		AppleInfo:            map[string]AppleInfo{},
+		ZipBatchBytes:        defaultZipBatchBytes,
+		DirectThresholdBytes: defaultDirectThresholdBytes,
+		Downloader:           DownloaderGrab,
+		Retry:                defaultRetry,
+		RateLimit:            defaultRateLimit,
+	}
+	defaultRetry = map[util.EndpointClass]RetryConfig{
+		util.EndpointAuth:     {Attempts: 2, BaseDelayMs: 1000, MaxDelayMs: 5000},
+		util.EndpointQuery:    {Attempts: 4, BaseDelayMs: 1000, MaxDelayMs: 30000},
+		util.EndpointDownload: {Attempts: 5, BaseDelayMs: 2000, MaxDelayMs: 60000},
+	}
+	// queryはrecords/queryやzip/prepareなどのメタデータ系
+	defaultRateLimit = map[util.EndpointClass]util.RateLimit{
+		util.EndpointAuth:     {PerSecond: 1, Burst: 3},
+		util.EndpointQuery:    {PerSecond: 5, Burst: 10},
+		util.EndpointDownload: {PerSecond: 10, Burst: 20},
 	}
 )
 
+var (
+	confMu sync.Mutex
+	// 転送中に毎秒参照されるので、ファイルは読まずに読み込み時と保存時の値を使う
+	bandwidthConf atomic.Pointer[BandwidthConfig]
+)
+
 func saveConf(config ConfigFile) {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	saveConfLocked(config)
+}
+
+func saveConfLocked(config ConfigFile) {
 	configFilePath := filepath.Join(configDir, configFile)
 	if err := os.WriteFile(configFilePath, util.MustMarshal(config), 0777); err != nil {
 		panic(err)
 	}
+	cacheConfLocked(config)
+}
+
+func cacheConfLocked(config ConfigFile) {
 	confCache = &config
+	bandwidth := config.Bandwidth
+	bandwidthConf.Store(&bandwidth)
 }
 
 func loadConf() ConfigFile {
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	return loadConfLocked()
+}
+
+// ファイルを読むのは最初の1回だけ、以降は保存した内容を返す
+func loadConfLocked() ConfigFile {
 	if confCache != nil {
 		return *confCache
 	}
@@ -62,9 +154,11 @@ func loadConf() ConfigFile {
 	if err != nil {
 		panic(err)
 	}
+	cacheConfLocked(*c)
 
 	return *c
 }
+
 func InitConfig(appDir string) {
 	configDir = appDir
 	if err := os.MkdirAll(configDir, 0777); err != nil {
@@ -81,6 +175,8 @@ func InitConfig(appDir string) {
 		open.Start(appDir)
 		os.Exit(0)
 	}
+	// 読めない場合は起動時に落とす、ネットワークの設定もここで確認する
+	initTransport(loadConf().Network)
 }
 
 func WithCacheConfig(ctx context.Context) context.Context {
@@ -105,6 +201,111 @@ func Config(ctx context.Context) ConfigFile {
 	return loadConf()
 }
 
+func (c ConfigFile) ZipBatchSize() int64 {
+	if c.ZipBatchBytes <= 0 {
+		return defaultZipBatchBytes
+	}
+	return c.ZipBatchBytes
+}
+
+func (c ConfigFile) DirectThreshold() int64 {
+	if c.DirectThresholdBytes <= 0 {
+		return defaultDirectThresholdBytes
+	}
+	return c.DirectThresholdBytes
+}
+
+// 設定ファイルにないクラスはデフォルトを使う
+func (c ConfigFile) RetryPolicies() map[util.EndpointClass]util.RetryPolicy {
+	policies := map[util.EndpointClass]util.RetryPolicy{}
+	for class, rc := range defaultRetry {
+		if v, ok := c.Retry[class]; ok {
+			rc = v
+		}
+		policies[class] = util.RetryPolicy{
+			Attempts:  rc.Attempts,
+			BaseDelay: time.Duration(rc.BaseDelayMs) * time.Millisecond,
+			MaxDelay:  time.Duration(rc.MaxDelayMs) * time.Millisecond,
+		}
+	}
+	return policies
+}
+
+// PerSecondが0以下のクラスは制限しない
+func (c ConfigFile) RateLimits() map[util.EndpointClass]util.RateLimit {
+	limits := map[util.EndpointClass]util.RateLimit{}
+	for class, limit := range defaultRateLimit {
+		if v, ok := c.RateLimit[class]; ok {
+			limit = v
+		}
+		limits[class] = limit
+	}
+	return limits
+}
+
+func SharedRateLimiters() *util.RateLimiters {
+	return util.SharedRateLimiters(func() map[util.EndpointClass]util.RateLimit {
+		return Config(context.TODO()).RateLimits()
+	})
+}
+
+func (b BandwidthConfig) LimitAt(now time.Time) int64 {
+	if !b.UseSchedule {
+		return b.LimitBytes
+	}
+
+	for _, w := range b.Schedule {
+		if w.contains(now) {
+			return w.LimitBytes
+		}
+	}
+	return b.LimitBytes
+}
+
+func (w BandwidthWindow) Validate() error {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return fmt.Errorf("invalid start %q: %w", w.Start, err)
+	}
+	if _, err := time.Parse("15:04", w.End); err != nil {
+		return fmt.Errorf("invalid end %q: %w", w.End, err)
+	}
+	if w.LimitBytes < 0 {
+		return fmt.Errorf("invalid limit %d", w.LimitBytes)
+	}
+	return nil
+}
+
+func (w BandwidthWindow) contains(now time.Time) bool {
+	start, err1 := time.Parse("15:04", w.Start)
+	end, err2 := time.Parse("15:04", w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	minutes := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	m, s, e := minutes(now), minutes(start), minutes(end)
+	if s <= e {
+		return s <= m && m < e
+	}
+	return m >= s || m < e
+}
+
+func SharedBandwidthLimiter() *util.BandwidthLimiter {
+	return util.SharedBandwidthLimiter(func() int64 {
+		bandwidth := bandwidthConf.Load()
+		if bandwidth == nil {
+			return 0
+		}
+		return bandwidth.LimitAt(time.Now())
+	})
+}
+
+// 最新の設定を読んで書き換える、同時に呼ばれても変更が失われない
 func CacheConfig(v func(*ConfigFile)) {
-	PeekConfig(context.TODO(), v)
+	confMu.Lock()
+	defer confMu.Unlock()
+
+	config := loadConfLocked()
+	v(&config)
+	saveConfLocked(config)
 }
@@ -0,0 +1,153 @@
+package appctx
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	FileQueued      FileState = "queued"
+	FileDownloading FileState = "downloading"
+	FileVerifying   FileState = "verifying"
+	FileDone        FileState = "done"
+	FileFailed      FileState = "failed"
+
+	fileEventBuffer = 256
+)
+
+type (
+	FileState  string
+	FileStatus struct {
+		Key       string    `json:"key"`
+		Filename  string    `json:"filename"`
+		PhotoIDs  []string  `json:"photoIds"`
+		State     FileState `json:"state"`
+		Reason    string    `json:"reason,omitempty"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	// Activeはdownloadingとverifyingの合計
+	FileCounts struct {
+		Queued int `json:"queued"`
+		Active int `json:"active"`
+		Done   int `json:"done"`
+		Failed int `json:"failed"`
+	}
+)
+
+// zipの場合はphotoIDsに中身の写真を入れる
+func (p *progress) QueueFile(key, filename string, photoIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setStatus(FileStatus{
+		Key:       key,
+		Filename:  filename,
+		PhotoIDs:  photoIDs,
+		State:     FileQueued,
+		UpdatedAt: time.Now(),
+	})
+}
+
+// filenameが空なら登録時の名前のまま
+func (p *progress) UpdateFile(key string, state FileState, filename string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := FileStatus{Key: key}
+	if old, ok := p.statuses[key]; ok {
+		status = *old
+	}
+	status.State = state
+	status.UpdatedAt = time.Now()
+	if filename != "" {
+		status.Filename = filename
+	}
+	status.Reason = ""
+	if err != nil {
+		status.Reason = err.Error()
+	}
+	p.setStatus(status)
+}
+
+// 完了したものは件数だけ数えて一覧から外す、大量のダウンロードでも失敗と処理中のものだけを持つ
+func (p *progress) setStatus(status FileStatus) {
+	if old, ok := p.statuses[status.Key]; ok {
+		p.fileCounts.add(old.State, -1)
+	} else {
+		p.statusSeq++
+		p.statusOrder[status.Key] = p.statusSeq
+	}
+	p.fileCounts.add(status.State, 1)
+
+	if status.State == FileDone {
+		delete(p.statuses, status.Key)
+		delete(p.statusOrder, status.Key)
+	} else {
+		p.statuses[status.Key] = &status
+	}
+	p.emitFile(status)
+	p.emit()
+}
+
+func (c *FileCounts) add(state FileState, n int) {
+	switch state {
+	case FileQueued:
+		c.Queued += n
+	case FileDownloading, FileVerifying:
+		c.Active += n
+	case FileDone:
+		c.Done += n
+	case FileFailed:
+		c.Failed += n
+	}
+}
+
+// 完了したものは含まない、件数はFileCountsで取る
+func (p *progress) FileStatuses() []FileStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]FileStatus, 0, len(p.statuses))
+	for _, status := range p.statuses {
+		result = append(result, *status)
+	}
+	slices.SortFunc(result, func(a, b FileStatus) int {
+		return cmp.Compare(p.statusOrder[a.Key], p.statusOrder[b.Key])
+	})
+	return result
+}
+
+func (p *progress) FileCounts() FileCounts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fileCounts
+}
+
+// 受け取り側が詰まっている場合は捨てる、捨てたことはTakeFileEventsDroppedで分かる
+func (p *progress) emitFile(status FileStatus) {
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.fileCh <- status:
+	default:
+		p.fileDropped = true
+	}
+}
+
+func (p *progress) FileEvents() <-chan FileStatus {
+	return p.fileCh
+}
+
+// 前回から捨てたイベントがあればtrue、受け取り側はFileStatusesで取り直す
+func (p *progress) TakeFileEventsDropped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dropped := p.fileDropped
+	p.fileDropped = false
+	return dropped
+}
@@ -0,0 +1,175 @@
+package appctx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+const (
+	JobDownload JobKind = "download"
+	JobVerify   JobKind = "verify"
+	JobSync     JobKind = "sync" // 定期ダウンロード
+	JobExport   JobKind = "export"
+
+	JobRunning     JobStatus = "running"
+	JobDone        JobStatus = "done"
+	JobFailed      JobStatus = "failed"
+	JobCanceled    JobStatus = "canceled"
+	JobInterrupted JobStatus = "interrupted"
+
+	jobsFilename = "jobs.json"
+	// 古いものから捨てる
+	maxJobHistory = 200
+	// 1ジョブで残すエラーの数
+	maxJobErrors = 100
+)
+
+type (
+	JobKind   string
+	JobStatus string
+	Job       struct {
+		ID         string     `json:"id"`
+		Kind       JobKind    `json:"kind"`
+		Status     JobStatus  `json:"status"`
+		Dir        string     `json:"dir,omitempty"`
+		Pid        int        `json:"pid,omitempty"`
+		StartedAt  time.Time  `json:"startedAt"`
+		FinishedAt *time.Time `json:"finishedAt,omitempty"`
+		BytesDone  float64    `json:"bytesDone"`
+		BytesTotal float64    `json:"bytesTotal"`
+		Errors     []string   `json:"errors"`
+	}
+)
+
+var (
+	jobsDir = ""
+	jobsMu  sync.Mutex
+)
+
+func InitJobs(appDir string) {
+	jobsDir = appDir
+	if err := os.MkdirAll(jobsDir, 0777); err != nil {
+		panic(err)
+	}
+}
+
+// 動かしていたプロセスが居なくなったジョブは中断扱いにする、ジョブを動かす側の起動時だけ呼ぶ
+func MarkInterruptedJobs() error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	unlock, err := lockJobs()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	jobs, err := loadJobs()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range jobs {
+		if jobs[i].Status == JobRunning && !util.ProcessAlive(jobs[i].Pid) {
+			jobs[i].Status = JobInterrupted
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveJobs(jobs)
+}
+
+func NewJob(kind JobKind, dir string) Job {
+	return Job{
+		ID:        util.MustUUID(),
+		Kind:      kind,
+		Status:    JobRunning,
+		Dir:       dir,
+		Pid:       os.Getpid(),
+		StartedAt: time.Now(),
+		Errors:    []string{},
+	}
+}
+
+func (j *Job) AddError(err string) {
+	if len(j.Errors) < maxJobErrors {
+		j.Errors = append(j.Errors, err)
+	}
+}
+
+func (j *Job) Finish(status JobStatus) {
+	now := time.Now()
+	j.Status = status
+	j.FinishedAt = &now
+}
+
+// 同じIDがあれば置き換える、読めない履歴は上書きしない
+func SaveJob(job Job) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	unlock, err := lockJobs()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	jobs, err := loadJobs()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(jobs, func(j Job) bool { return j.ID == job.ID })
+	if i >= 0 {
+		jobs[i] = job
+	} else {
+		jobs = append(jobs, job)
+	}
+	if len(jobs) > maxJobHistory {
+		jobs = jobs[len(jobs)-maxJobHistory:]
+	}
+	return saveJobs(jobs)
+}
+
+// 新しいものから返す
+func JobHistory() ([]Job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	jobs, err := loadJobs()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(jobs)
+	return jobs, nil
+}
+
+func saveJobs(jobs []Job) error {
+	if err := util.WriteFileAtomic(filepath.Join(jobsDir, jobsFilename), util.MustMarshal(jobs), 0777); err != nil {
+		return fmt.Errorf("failed to save jobs: %w", err)
+	}
+	return nil
+}
+
+// まだ無い場合は空
+func loadJobs() ([]Job, error) {
+	byts, err := os.ReadFile(filepath.Join(jobsDir, jobsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Job{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+
+	jobs, err := util.Unmarshal[[]Job](byts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", jobsFilename, err)
+	}
+	return *jobs, nil
+}
@@ -0,0 +1,79 @@
+package appctx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+const (
+	scheduleLockFilename = "schedule.lock"
+	jobsLockFilename     = "jobs.lock"
+	// jobs.jsonの読み書きはすぐ終わるので、これ以上待つ場合は諦める
+	jobsLockTimeout = 5 * time.Second
+)
+
+var ErrLocked = errors.New("locked by another process")
+
+// 定期ダウンロードをGUIとデーモンで同時に動かさないためのロック、InitJobsの後に使う
+func LockSchedule() (func(), error) {
+	return lockFile(scheduleLockFilename)
+}
+
+// GUIとデーモンでjobs.jsonを同時に書き換えないためのロック、空くまで待つ
+func lockJobs() (func(), error) {
+	deadline := time.Now().Add(jobsLockTimeout)
+	for {
+		unlock, err := lockFile(jobsLockFilename)
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return unlock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// 持っていたプロセスが居なくなっていれば取り直す
+func lockFile(name string) (func(), error) {
+	path := filepath.Join(jobsDir, name)
+	for range 2 {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()))
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create %s: %w", name, err)
+		}
+
+		byts, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(byts)))
+		if util.ProcessAlive(pid) || (pid == 0 && justCreated(path)) {
+			return nil, fmt.Errorf("%s (pid %d): %w", name, pid, ErrLocked)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale %s: %w", name, err)
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", name, ErrLocked)
+}
+
+// 作った直後でまだPIDが書かれていないロックを、持ち主が居ないものと間違えないため
+func justCreated(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) < time.Second
+}
@@ -0,0 +1,82 @@
+package appctx
+
+import (
+	"context"
+	"sync"
+)
+
+const pauseKey contextKey = "pause"
+
+type (
+	Pauser struct {
+		mu      sync.Mutex
+		resumed chan struct{}
+	}
+)
+
+func NewPauser() *Pauser {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &Pauser{resumed: resumed}
+}
+
+func (p *Pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused() {
+		return
+	}
+	p.resumed = make(chan struct{})
+}
+
+func (p *Pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused() {
+		close(p.resumed)
+	}
+}
+
+func (p *Pauser) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused()
+}
+
+func (p *Pauser) paused() bool {
+	select {
+	case <-p.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *Pauser) wait(ctx context.Context) error {
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func WithPauser(ctx context.Context, p *Pauser) context.Context {
+	return context.WithValue(ctx, pauseKey, p)
+}
+
+// 一時停止中なら再開されるまで待つ、次のファイルやセグメントに進む前に呼ぶ
+func WaitResume(ctx context.Context) error {
+	p, ok := ctx.Value(pauseKey).(*Pauser)
+	if !ok {
+		return ctx.Err()
+	}
+	return p.wait(ctx)
+}
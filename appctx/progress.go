@@ -2,93 +2,254 @@ package appctx
 
 import (
 	"context"
-	"log/slog"
+	"sync"
 	"time"
-
-	"github.com/take0244/go-icloud-photo-gui/util"
 )
 
-const processKey contextKey = "process"
+const (
+	processKey contextKey = "process"
+
+	rateInterval = 500 * time.Millisecond
+	// 直近の速度をどれだけ重視するか
+	rateSmoothing = 0.3
+)
 
 type (
+	ProgressEvent struct {
+		Phase       string     `json:"phase"`
+		Value       float64    `json:"value"`
+		FilesDone   float64    `json:"filesDone"`
+		FilesTotal  float64    `json:"filesTotal"`
+		BytesDone   float64    `json:"bytesDone"`
+		BytesTotal  float64    `json:"bytesTotal"`
+		BytesPerSec float64    `json:"bytesPerSec"`
+		EtaSeconds  float64    `json:"etaSeconds"`
+		Listed      float64    `json:"listed"`
+		ListTotal   float64    `json:"listTotal"`
+		Files       FileCounts `json:"files"`
+	}
+	fileProgress struct {
+		done     float64
+		total    float64
+		finished bool
+	}
 	progress struct {
-		total  float64
-		cntMap map[string]float64
-		keys   []string
+		mu     sync.Mutex
 		phase  string
-		ch     chan float64
+		files  map[string]*fileProgress
+		ch     chan ProgressEvent
+		fileCh chan FileStatus
+		closed bool
+
+		statuses    map[string]*FileStatus
+		statusOrder map[string]int
+		statusSeq   int
+		fileCounts  FileCounts
+		fileDropped bool
+
+		filesTotal   float64
+		bytesTotal   float64
+		filesDone    float64
+		transferred  float64
+		skippedFiles float64
+		skippedBytes float64
+
+		listed    float64
+		listTotal float64
+
+		rateBytes float64
+		rateAt    time.Time
+		rate      float64
 	}
 )
 
 func newProgress() *progress {
 	p := &progress{
-		ch: make(chan float64, 10),
+		ch:     make(chan ProgressEvent, 1),
+		fileCh: make(chan FileStatus, fileEventBuffer),
 	}
-	p.SetPhase("", 0)
+	p.SetPhase("")
 	return p
 }
 
-func (p *progress) makeCh() {
-	if p.ch == nil || util.IsClosed(p.ch) {
-		p.ch = make(chan float64, 50)
-	}
+// フェーズが変わったら件数、バイト数、速度を数え直す
+func (p *progress) SetPhase(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.phase = phase
+	p.files = map[string]*fileProgress{}
+	p.statuses = map[string]*FileStatus{}
+	p.statusOrder = map[string]int{}
+	p.fileCounts = FileCounts{}
+	p.filesTotal = 0
+	p.bytesTotal = 0
+	p.filesDone = 0
+	p.transferred = 0
+	p.skippedFiles = 0
+	p.skippedBytes = 0
+	p.listed = 0
+	p.listTotal = 0
+	p.rateBytes = 0
+	p.rateAt = time.Now()
+	p.rate = 0
+	p.emit()
 }
 
-func (p *progress) value() float64 {
-	var result = float64(0)
-	for _, c := range p.cntMap {
-		result += c
-	}
+func (p *progress) AddTotal(files int, bytes float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	return result / p.total
+	p.filesTotal += float64(files)
+	p.bytesTotal += bytes
+	p.emit()
 }
 
-func (p *progress) SetPhase(phase string, total float64) {
-	p.phase = phase
-	p.total = total
-	p.cntMap = map[string]float64{}
-	p.keys = []string{}
-	p.makeCh()
-	util.SendOrTimeout(p.ch, 0, time.Second*1)
+// 前回までに終わっている分、速度には含めない
+func (p *progress) AddDone(files int, bytes float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.filesTotal += float64(files)
+	p.bytesTotal += bytes
+	p.skippedFiles += float64(files)
+	p.skippedBytes += bytes
+	p.emit()
 }
 
-func (p *progress) Count(key string, value float64) {
-	_, ok := p.cntMap[key]
+// totalが分からない場合は0
+func (p *progress) Track(key string, done, total float64, finished bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, ok := p.files[key]
 	if !ok {
-		p.keys = append(p.keys, key)
+		f = &fileProgress{}
+		p.files[key] = f
 	}
-	p.cntMap[key] = value
+	files, bytes := f.contribution()
+	p.filesDone -= files
+	p.transferred -= bytes
+
+	f.done = done
+	f.total = total
+	f.finished = finished
+	files, bytes = f.contribution()
+	p.filesDone += files
+	p.transferred += bytes
+	p.emit()
+}
+
+func (p *progress) SetListing(listed, total float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listed = listed
+	p.listTotal = total
+	p.emit()
+}
 
-	if p.total == 0 {
-		if !util.SendOrTimeout(p.ch, 0, time.Millisecond*2) {
-			slog.WarnContext(context.TODO(), "Timeout channel")
+func (p *progress) Snapshot() ProgressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.snapshot()
+}
+
+func (p *progress) snapshot() ProgressEvent {
+	e := ProgressEvent{
+		Phase:      p.phase,
+		FilesTotal: p.filesTotal,
+		BytesTotal: p.bytesTotal,
+		FilesDone:  p.skippedFiles + p.filesDone,
+		BytesDone:  p.skippedBytes + p.transferred,
+		Listed:     p.listed,
+		ListTotal:  p.listTotal,
+		Files:      p.fileCounts,
+	}
+
+	now := time.Now()
+	if elapsed := now.Sub(p.rateAt); elapsed >= rateInterval {
+		current := max(p.transferred-p.rateBytes, 0) / elapsed.Seconds()
+		if p.rate == 0 {
+			p.rate = current
+		} else {
+			p.rate = rateSmoothing*current + (1-rateSmoothing)*p.rate
 		}
-		return
+		p.rateBytes = p.transferred
+		p.rateAt = now
 	}
+	e.BytesPerSec = p.rate
 
-	if !util.SendOrTimeout(p.ch, p.value(), time.Millisecond*2) {
-		slog.WarnContext(context.TODO(), "Timeout channel")
+	switch {
+	case e.BytesTotal > 0:
+		e.Value = min(e.BytesDone/e.BytesTotal, 1)
+	case e.FilesTotal > 0:
+		e.Value = min(e.FilesDone/e.FilesTotal, 1)
+	}
+	if p.rate > 0 && e.BytesTotal > e.BytesDone {
+		e.EtaSeconds = (e.BytesTotal - e.BytesDone) / p.rate
 	}
+
+	return e
 }
 
-func (p *progress) Value() <-chan float64 {
-	return p.ch
+func (f *fileProgress) contribution() (float64, float64) {
+	// 合計と合わせるため、サイズが分かっているものはその値で数える
+	switch {
+	case f.finished && f.total > 0:
+		return 1, f.total
+	case f.finished:
+		return 1, f.done
+	case f.total > 0:
+		return min(f.done/f.total, 0.999999), min(f.done, f.total)
+	default:
+		return 0, f.done
+	}
 }
 
-func (p *progress) Phase() string {
-	return p.phase
+// 受け取り側が遅れても最新の状態だけを残す
+func (p *progress) emit() {
+	if p.closed {
+		return
+	}
+
+	e := p.snapshot()
+	select {
+	case <-p.ch:
+	default:
+	}
+	select {
+	case p.ch <- e:
+	default:
+	}
+}
+
+func (p *progress) Events() <-chan ProgressEvent {
+	return p.ch
 }
 
 func (p *progress) Close() error {
-	if p.ch != nil && !util.IsClosed(p.ch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
 		close(p.ch)
+		close(p.fileCh)
 	}
 	return nil
 }
 
 func WithProgress(ctx context.Context) context.Context {
 	if p, ok := Progress(ctx); ok {
-		p.makeCh()
+		p.mu.Lock()
+		if p.closed {
+			p.ch = make(chan ProgressEvent, 1)
+			p.fileCh = make(chan FileStatus, fileEventBuffer)
+			p.closed = false
+		}
+		p.mu.Unlock()
 		return ctx
 	}
 
@@ -0,0 +1,44 @@
+package appctx
+
+import (
+	"context"
+)
+
+const slotsKey contextKey = "slots"
+
+// 1回の実行の中で直接ダウンロードとzipのダウンロードが共有する同時接続数
+type downloadSlots chan struct{}
+
+func WithDownloadSlots(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, slotsKey, make(downloadSlots, max(n, 1)))
+}
+
+// 空きができるまで待つ、終わったら返された関数で解放する
+func AcquireDownloadSlot(ctx context.Context) (func(), error) {
+	slots, ok := ctx.Value(slotsKey).(downloadSlots)
+	if !ok {
+		return func() {}, ctx.Err()
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// 空きが無ければ待たずにfalseを返す、分割ダウンロードで追加の接続を使う場合に呼ぶ
+func TryAcquireDownloadSlot(ctx context.Context) (func(), bool) {
+	slots, ok := ctx.Value(slotsKey).(downloadSlots)
+	if !ok {
+		return func() {}, true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,65 @@
+package appctx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/take0244/go-icloud-photo-gui/aop"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+var (
+	baseTransport     http.RoundTripper
+	baseTransportOnce sync.Once
+	networkErr        error
+)
+
+type errorTransport struct {
+	err error
+}
+
+// 設定が間違っている場合は指定されたプロキシを迂回しないように、直すまで通信しない
+// エラーはNetworkConfigErrorで知らせる
+func initTransport(opts util.TransportOptions) {
+	baseTransportOnce.Do(func() {
+		transport, err := util.NewHttpTransport(opts)
+		if err != nil {
+			networkErr = fmt.Errorf("invalid Network config: %w", err)
+			baseTransport = errorTransport{err: networkErr}
+			return
+		}
+		baseTransport = transport
+	})
+}
+
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
+
+func NetworkConfigError() error {
+	return networkErr
+}
+
+// 認証・メタデータ・ダウンロードで同じプロキシ、TLS設定とコネクションを使う
+func HttpTransport(class util.EndpointClass) http.RoundTripper {
+	config := Config(context.TODO())
+	initTransport(config.Network)
+
+	transport := baseTransport
+	if aop.IsDebug() && class != util.EndpointDownload {
+		transport = util.NewCacheTransport(transport, "./.cache")
+	}
+	transport = util.NewLoggingTransport(transport)
+	transport = util.NewRateLimitTransport(transport, SharedRateLimiters(), class)
+	transport = util.NewRetryTransport(transport, config.RetryPolicies(), class)
+	if class == util.EndpointDownload {
+		transport = util.NewThrottleTransport(transport, SharedBandwidthLimiter())
+	}
+
+	return transport
+}
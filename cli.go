@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	infraui "github.com/take0244/go-icloud-photo-gui/infrastructure/presentation/ui"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+// 知らないサブコマンドの場合はfalseを返してGUIを起動する
+func runCommand(args []string, ucase usecase.UseCase) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "jobs":
+		fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+		asJson := fs.Bool("json", false, "print as json")
+		limit := fs.Int("n", 20, "number of jobs to print, 0 for all")
+		fs.Parse(args[1:])
+
+		jobs, err := appctx.JobHistory()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		printJobs(os.Stdout, jobs, *limit, *asJson)
+		return true
+	case "verify":
+		// manifestにあるファイルが残っているかを確認する
+		fs := flag.NewFlagSet("verify", flag.ExitOnError)
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: verify <dir>")
+			os.Exit(2)
+		}
+		ctx, stop := signal.NotifyContext(appctx.NewAppContext(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		job, err := infraui.RunVerify(ctx, ucase, fs.Arg(0))
+		for _, e := range job.Errors {
+			fmt.Println(e)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return true
+	case "export":
+		// manifestの一覧をCSVで書き出す
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: export <dir> <file.csv>")
+			os.Exit(2)
+		}
+		ctx, stop := signal.NotifyContext(appctx.NewAppContext(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if _, err := infraui.RunExport(ctx, ucase, fs.Arg(0), fs.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return true
+	case "daemon":
+		// app_config.jsonのScheduleに従って、止められるまでダウンロードを繰り返す
+		ctx, stop := signal.NotifyContext(appctx.NewAppContext(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := appctx.MarkInterruptedJobs(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := infraui.RunDaemon(ctx, ucase); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return true
+	}
+
+	return false
+}
+
+func printJobs(w io.Writer, jobs []appctx.Job, limit int, asJson bool) {
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	if asJson {
+		fmt.Fprintln(w, util.MustJsonString(jobs))
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tSTARTED\tFINISHED\tBYTES\tERRORS\tDIR")
+	for _, j := range jobs {
+		finished := "-"
+		if j.FinishedAt != nil {
+			finished = j.FinishedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.0f/%.0f\t%d\t%s\n",
+			j.ID, j.Kind, j.Status, j.StartedAt.Format(time.DateTime), finished,
+			j.BytesDone, j.BytesTotal, len(j.Errors), j.Dir,
+		)
+	}
+}
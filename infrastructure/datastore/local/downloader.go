@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"math"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/cavaliergopher/grab/v3"
@@ -17,6 +18,7 @@ import (
 
 type (
 	downloader struct {
+		manifestStore
 		client *grab.Client
 	}
 )
@@ -26,86 +28,235 @@ func NewDownloader() *downloader {
 		client: &grab.Client{
 			UserAgent: util.UserAgent,
 			HTTPClient: &http.Client{
-				Transport: util.NewLoggingTransport(&http.Transport{
-					Proxy: http.ProxyFromEnvironment,
-				}),
+				Transport: appctx.HttpTransport(util.EndpointDownload),
 			},
 		},
 	}
 }
 
-func (d *downloader) DownloadFileUrls(ctx context.Context, dir string, urls []usecase.FileUrl, workers int) error {
-	requests, err := d.cnvGrabRequest(dir, urls)
+func (d *downloader) DownloadFileUrls(ctx context.Context, dir string, urls []usecase.FileUrl, workers int) ([]usecase.FailedFile, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	requests, err := d.cnvGrabRequest(ctx, dir, urls)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	progressIds := progressKeys(urls)
+	indexes := map[*grab.Request]int{}
+	before := map[*grab.Request]fileState{}
+	for i, req := range requests {
+		indexes[req] = i
+		if req.Filename != "" {
+			before[req] = statFile(req.Filename)
+		}
+	}
+	respch := d.doBatch(ctx, workers, requests)
+
+	started := []*grab.Response{}
+	defer func() {
+		// 途中で止めた場合も書きかけのファイルを残さない
+		// 書き始める前に失敗したものは元からあったファイルなので消さない
+		cancel(context.Canceled)
+		for resp := range respch {
+			started = append(started, resp)
+		}
+		for _, resp := range started {
+			<-resp.Done
+			if resp.Err() == nil || resp.Filename == "" {
+				continue
+			}
+			if state, ok := before[resp.Request]; !ok || state.changed(resp.Filename) {
+				os.Remove(resp.Filename)
+			}
+		}
+	}()
+
+	var failed []usecase.FailedFile
+	settled := make([]bool, len(urls))
+	fail := func(i int, err error) {
+		slog.WarnContext(ctx, "Download failed", slog.String("url", urls[i].Url), slog.String("error", err.Error()))
+		failed = append(failed, usecase.FailedFile{File: urls[i], Err: fmt.Errorf("grab download error: %w", err)})
 	}
-	progressIds := util.GenerateUniqKeys(len(requests))
-	respch := d.client.DoBatch(workers, requests...)
 
 	p, ok := appctx.Progress(ctx)
 	if !ok {
 		for resp := range respch {
+			started = append(started, resp)
+			i := indexes[resp.Request]
+			settled[i] = true
 			if err := resp.Err(); err != nil {
-				return err
+				fail(i, err)
 			}
 		}
-		return nil
+		return failedOrCause(ctx, urls, settled, failed)
 	}
 
 	t := time.NewTicker(100 * time.Millisecond)
 	defer t.Stop()
-	responses := []*grab.Response{}
-	countNil := func() int {
-		var count = 0
-		for _, r := range responses {
-			if r == nil {
-				count++
-			}
-		}
-		return count
-	}
+	responses := map[int]*grab.Response{}
 
-	for countNil() < len(requests) {
+	for received := respch; received != nil || len(responses) > 0; {
 		select {
-		case resp := <-respch:
-			if resp != nil {
-				slog.InfoContext(ctx, "Loaded Response", slog.String("filename", resp.Filename))
-				responses = append(responses, resp)
+		case resp, ok := <-received:
+			if !ok {
+				received = nil
+				continue
 			}
+			started = append(started, resp)
+			slog.InfoContext(ctx, "Loaded Response", slog.String("filename", resp.Filename))
+			i := indexes[resp.Request]
+			responses[i] = resp
+			p.UpdateFile(progressIds[i], appctx.FileDownloading, filepath.Base(resp.Filename), nil)
 		case <-t.C:
 			for i, resp := range responses {
-				if resp == nil {
-					continue
-				}
 				select {
 				case <-resp.Done:
+					delete(responses, i)
+					settled[i] = true
 					if err := resp.Err(); err != nil {
-						return fmt.Errorf("grab download error: %w", err)
+						p.UpdateFile(progressIds[i], appctx.FileFailed, "", err)
+						fail(i, err)
+						continue
 					}
-					responses[i] = nil
-					p.Count(progressIds[i], 1)
-				default:
-					fileProgress := float64(0)
-					if urls[i].FileSize != 0 {
-						fileProgress = math.Min(float64(resp.BytesComplete())/(urls[i].FileSize*0.95), 0.999999)
+					p.UpdateFile(progressIds[i], appctx.FileVerifying, "", nil)
+					if err := verifyFile(resp.Filename, resp.Size()); err != nil {
+						os.Remove(resp.Filename)
+						p.UpdateFile(progressIds[i], appctx.FileFailed, "", err)
+						fail(i, err)
+						continue
 					}
-					p.Count(progressIds[i], math.Max(fileProgress, resp.Progress()))
+					p.Track(progressIds[i], float64(resp.BytesComplete()), fileSize(urls[i], resp), true)
+					p.UpdateFile(progressIds[i], appctx.FileDone, "", nil)
+				default:
+					p.Track(progressIds[i], float64(resp.BytesComplete()), fileSize(urls[i], resp), false)
 				}
 			}
 		}
 	}
 
+	return failedOrCause(ctx, urls, settled, failed)
+}
+
+// 止められた場合は終わっていないファイルも失敗に含めて、理由と一緒に返す
+func failedOrCause(ctx context.Context, urls []usecase.FileUrl, settled []bool, failed []usecase.FailedFile) ([]usecase.FailedFile, error) {
+	err := context.Cause(ctx)
+	if err == nil {
+		return failed, nil
+	}
+	for i, ok := range settled {
+		if !ok {
+			failed = append(failed, usecase.FailedFile{File: urls[i], Err: err})
+		}
+	}
+	return failed, err
+}
+
+// Keyがない場合は進捗用に作る
+func progressKeys(urls []usecase.FileUrl) []string {
+	keys := util.GenerateUniqKeys(len(urls))
+	for i, url := range urls {
+		if url.Key != "" {
+			keys[i] = url.Key
+		}
+	}
+	return keys
+}
+
+// サイズが分からない場合は確認しない
+func verifyFile(filename string, size int64) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if size > 0 && info.Size() != size {
+		return fmt.Errorf("size mismatch %s: %d != %d", filepath.Base(filename), info.Size(), size)
+	}
 	return nil
 }
 
-func (d downloader) cnvGrabRequest(dir string, files []usecase.FileUrl) ([]*grab.Request, error) {
+// ダウンロード前のファイルの状態
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFile(filename string) fileState {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// 作った、または書き換えた場合にtrue
+func (s fileState) changed(filename string) bool {
+	now := statFile(filename)
+	return now.exists != s.exists || now.size != s.size || !now.modTime.Equal(s.modTime)
+}
+
+// 呼び出し元でサイズが分からない場合はレスポンスから取る
+func fileSize(url usecase.FileUrl, resp *grab.Response) float64 {
+	if url.FileSize > 0 {
+		return url.FileSize
+	}
+	return float64(max(resp.Size(), 0))
+}
+
+// grab.DoBatchと同じだが、一時停止中は次のファイルを始めない
+func (d *downloader) doBatch(ctx context.Context, workers int, requests []*grab.Request) <-chan *grab.Response {
+	respch := make(chan *grab.Response, len(requests))
+	reqch := make(chan *grab.Request)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range reqch {
+				release, err := appctx.AcquireDownloadSlot(ctx)
+				if err != nil {
+					continue
+				}
+				resp := d.client.Do(req)
+				respch <- resp
+				<-resp.Done
+				release()
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(reqch)
+			wg.Wait()
+			close(respch)
+		}()
+		for _, req := range requests {
+			if err := appctx.WaitResume(ctx); err != nil {
+				return
+			}
+			select {
+			case reqch <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return respch
+}
+
+func (d downloader) cnvGrabRequest(ctx context.Context, dir string, files []usecase.FileUrl) ([]*grab.Request, error) {
 	requests := []*grab.Request{}
 	for _, url := range files {
 		req, err := grab.NewRequest(dir, url.Url)
-		req.NoResume = true
 		if err != nil {
 			return nil, fmt.Errorf("missing request grab%w", err)
 		}
+		req.NoResume = true
+		req = req.WithContext(ctx)
 
 		if url.Filename != "" {
 			req.Filename = filepath.Join(dir, url.Filename)
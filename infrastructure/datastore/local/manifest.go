@@ -0,0 +1,155 @@
+package ifstorelocal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+const (
+	manifestFilename   = "manifest.jsonl"
+	quarantineFilename = "quarantine.jsonl"
+	reportFilename     = "report.json"
+	retryFilename      = "retry.jsonl"
+)
+
+type manifestStore struct{}
+
+func (m manifestStore) AppendManifest(ctx context.Context, dir string, entries []usecase.ManifestEntry) error {
+	return appendJSONLines(filepath.Join(dir, manifestFilename), entries)
+}
+
+func (m manifestStore) LoadManifest(ctx context.Context, dir string) ([]usecase.ManifestEntry, error) {
+	return readJSONLines[usecase.ManifestEntry](ctx, filepath.Join(dir, manifestFilename))
+}
+
+func (m manifestStore) AppendQuarantine(ctx context.Context, dir string, records []usecase.MalformedRecord) error {
+	return appendJSONLines(filepath.Join(dir, quarantineFilename), records)
+}
+
+func (m manifestStore) LoadRetryList(ctx context.Context, dir string) ([]usecase.RetryEntry, error) {
+	return readJSONLines[usecase.RetryEntry](ctx, filepath.Join(dir, retryFilename))
+}
+
+// 前回の失敗分は置き換える、失敗がなければファイルを消す
+func (m manifestStore) WriteRetryList(ctx context.Context, dir string, entries []usecase.RetryEntry) error {
+	path := filepath.Join(dir, retryFilename)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", retryFilename, err)
+		}
+		return nil
+	}
+
+	var buf []byte
+	for _, e := range entries {
+		buf = append(buf, util.MustMarshal(e)...)
+		buf = append(buf, '\n')
+	}
+	if err := os.WriteFile(path, buf, 0777); err != nil {
+		return fmt.Errorf("failed to write %s: %w", retryFilename, err)
+	}
+	return nil
+}
+
+// 書き込み途中で落ちた行は読み飛ばす
+func readJSONLines[T any](ctx context.Context, path string) ([]T, error) {
+	name := filepath.Base(path)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	values := []T{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		v, err := util.Unmarshal[T](scanner.Bytes())
+		if err != nil {
+			slog.WarnContext(ctx, "Skip broken line", slog.String("file", name), slog.String("error", err.Error()))
+			continue
+		}
+		values = append(values, *v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return values, nil
+}
+
+func appendJSONLines[T any](path string, values []T) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	for _, v := range values {
+		if _, err := file.Write(append(util.MustMarshal(v), '\n')); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	return nil
+}
+
+// 大きさが分からないものは有るかだけを見る
+func (m manifestStore) VerifyFile(ctx context.Context, dir string, entry usecase.ManifestEntry) error {
+	return verifyFile(filepath.Join(dir, entry.Filename), int64(entry.FileSize))
+}
+
+// 1行目は見出し
+func (m manifestStore) WriteManifestCSV(ctx context.Context, path string, entries []usecase.ManifestEntry) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"id", "filename", "checkSum", "fileSize", "alternate", "pairedWith", "burstId", "class", "archived"})
+	for _, e := range entries {
+		w.Write([]string{
+			e.ID,
+			e.Filename,
+			e.CheckSum,
+			strconv.FormatFloat(e.FileSize, 'f', -1, 64),
+			strconv.FormatBool(e.Alternate),
+			e.PairedWith,
+			e.BurstID,
+			string(e.Class),
+			strconv.FormatBool(e.Archived),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+
+	if err := util.WriteFileAtomic(path, buf.Bytes(), 0777); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// 最後の実行結果だけを残す
+func (m manifestStore) WriteReport(ctx context.Context, dir string, report usecase.RunReport) error {
+	if err := os.WriteFile(filepath.Join(dir, reportFilename), util.MustMarshal(report), 0777); err != nil {
+		return fmt.Errorf("failed to write %s: %w", reportFilename, err)
+	}
+	return nil
+}
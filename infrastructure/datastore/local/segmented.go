@@ -0,0 +1,458 @@
+package ifstorelocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+const (
+	segmentSize     = 16 << 20
+	segmentParallel = 4
+	segmentRetry    = 4
+	segmentBackoff  = time.Second
+	partSuffix      = ".part"
+	partStateSuffix = ".part.json"
+)
+
+type (
+	segmentedDownloader struct {
+		manifestStore
+		client *http.Client
+	}
+	partState struct {
+		Size int64  `json:"size"`
+		Done []bool `json:"done"`
+	}
+	fileProgress struct {
+		done     atomic.Int64
+		total    atomic.Int64
+		finished atomic.Bool
+	}
+	probeResult struct {
+		size     int64
+		ranges   bool
+		filename string
+	}
+)
+
+func NewSegmentedDownloader() *segmentedDownloader {
+	return &segmentedDownloader{
+		client: &http.Client{
+			Transport: appctx.HttpTransport(util.EndpointDownload),
+		},
+	}
+}
+
+func (d *segmentedDownloader) DownloadFileUrls(ctx context.Context, dir string, urls []usecase.FileUrl, workers int) ([]usecase.FailedFile, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	keys := progressKeys(urls)
+	progresses := make([]fileProgress, len(urls))
+	stopReport := d.reportProgress(ctx, keys, progresses)
+	defer stopReport()
+
+	var (
+		failedMu sync.Mutex
+		failed   []usecase.FailedFile
+		settled  = make([]bool, len(urls))
+	)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := appctx.WaitResume(ctx); err != nil {
+					cancel(err)
+					return
+				}
+				release, err := appctx.AcquireDownloadSlot(ctx)
+				if err != nil {
+					cancel(err)
+					return
+				}
+				err = d.download(ctx, dir, urls[i], keys[i], &progresses[i])
+				release()
+				settled[i] = true
+				if err != nil {
+					updateFile(ctx, keys[i], appctx.FileFailed, "", err)
+					slog.WarnContext(ctx, "Download failed", slog.String("url", urls[i].Url), slog.String("error", err.Error()))
+					failedMu.Lock()
+					failed = append(failed, usecase.FailedFile{File: urls[i], Err: fmt.Errorf("segmented download error: %w", err)})
+					failedMu.Unlock()
+					continue
+				}
+				progresses[i].finished.Store(true)
+				updateFile(ctx, keys[i], appctx.FileDone, "", nil)
+			}
+		}()
+	}
+
+send:
+	for i := range urls {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return failedOrCause(ctx, urls, settled, failed)
+}
+
+func (d *segmentedDownloader) reportProgress(ctx context.Context, keys []string, progresses []fileProgress) func() {
+	p, ok := appctx.Progress(ctx)
+	if !ok {
+		return func() {}
+	}
+
+	report := func() {
+		for i := range progresses {
+			fp := &progresses[i]
+			p.Track(keys[i], float64(fp.done.Load()), float64(max(fp.total.Load(), 0)), fp.finished.Load())
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				report()
+			case <-done:
+				report()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (d *segmentedDownloader) download(ctx context.Context, dir string, file usecase.FileUrl, key string, fp *fileProgress) error {
+	probe, err := d.probe(ctx, file.Url)
+	if err != nil {
+		return err
+	}
+
+	filename := file.Filename
+	if filename == "" {
+		// サーバーが返した名前はgrabと同じくディレクトリを外して使う
+		name := probe.filename
+		if name == "" {
+			u, _ := url.Parse(file.Url)
+			name = u.Path
+		}
+		if filename, err = safeFilename(name); err != nil {
+			return err
+		}
+	}
+
+	target := filepath.Join(dir, filename)
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	fp.total.Store(probe.size)
+	updateFile(ctx, key, appctx.FileDownloading, filename, nil)
+
+	slog.InfoContext(ctx, "Download", slog.String("filename", filename), slog.Int64("size", probe.size), slog.Bool("ranges", probe.ranges))
+	if !probe.ranges || probe.size <= segmentSize {
+		err = util.Retry(ctx, segmentRetry, segmentBackoff, isRetryableDownload, func() error {
+			fp.done.Store(0)
+			return d.downloadSingle(ctx, file.Url, target, fp)
+		})
+	} else {
+		err = d.downloadSegments(ctx, file.Url, target, probe.size, fp)
+	}
+	if err != nil {
+		return err
+	}
+
+	updateFile(ctx, key, appctx.FileVerifying, "", nil)
+	if err := verifyFile(target+partSuffix, probe.size); err != nil {
+		os.Remove(target + partSuffix)
+		os.Remove(target + partStateSuffix)
+		return err
+	}
+	if err := os.Rename(target+partSuffix, target); err != nil {
+		return err
+	}
+	if err := os.Remove(target + partStateSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func updateFile(ctx context.Context, key string, state appctx.FileState, filename string, err error) {
+	if p, ok := appctx.Progress(ctx); ok {
+		p.UpdateFile(key, state, filename, err)
+	}
+}
+
+func (d *segmentedDownloader) probe(ctx context.Context, fileUrl string) (*probeResult, error) {
+	req := util.MustRequest(ctx, http.MethodGet, fileUrl, nil, map[string]string{
+		"Range":      "bytes=0-0",
+		"User-Agent": util.UserAgent,
+	})
+
+	var result *probeResult
+	err := util.Retry(ctx, segmentRetry, segmentBackoff, isRetryableDownload, func() error {
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			total := resp.Header.Get("Content-Range")
+			size, err := strconv.ParseInt(total[strings.LastIndex(total, "/")+1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid Content-Range %q: %w", total, err)
+			}
+			result = &probeResult{size: size, ranges: true, filename: util.FileNameFromResponse(resp)}
+		case http.StatusOK:
+			result = &probeResult{size: resp.ContentLength, filename: util.FileNameFromResponse(resp)}
+		default:
+			return &util.HttpError{StatusCode: resp.StatusCode, Header: resp.Header}
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (d *segmentedDownloader) downloadSingle(ctx context.Context, fileUrl, target string, fp *fileProgress) error {
+	req := util.MustRequest(ctx, http.MethodGet, fileUrl, nil, map[string]string{"User-Agent": util.UserAgent})
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !util.HttpCheck2XX(resp) {
+		return &util.HttpError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	part, err := os.Create(target + partSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, &countingReader{r: resp.Body, n: &fp.done}); err != nil {
+		// 分割しない場合は再開できないので消す
+		part.Close()
+		os.Remove(target + partSuffix)
+		return err
+	}
+	return part.Close()
+}
+
+func (d *segmentedDownloader) downloadSegments(ctx context.Context, fileUrl, target string, size int64, fp *fileProgress) error {
+	state := loadPartState(target, size)
+	part, err := os.OpenFile(target+partSuffix, os.O_CREATE|os.O_RDWR, 0777)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	if err := part.Truncate(size); err != nil {
+		return err
+	}
+
+	for i, done := range state.Done {
+		if done {
+			start, end := segmentRange(i, size)
+			fp.done.Add(end - start + 1)
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		segs = make(chan int)
+	)
+	// 1本目はファイルの分の枠を使い、2本目からは空いている枠がある分だけ増やす
+	for w := range segmentParallel {
+		release := func() {}
+		if w > 0 {
+			var ok bool
+			if release, ok = appctx.TryAcquireDownloadSlot(ctx); !ok {
+				break
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+			for i := range segs {
+				if err := appctx.WaitResume(ctx); err != nil {
+					cancel(err)
+					return
+				}
+				start, end := segmentRange(i, size)
+				err := util.Retry(ctx, segmentRetry, segmentBackoff, isRetryableDownload, func() error {
+					return d.fetchSegment(ctx, fileUrl, part, start, end, fp)
+				})
+				if err != nil {
+					cancel(fmt.Errorf("segment %d-%d: %w", start, end, err))
+					return
+				}
+
+				mu.Lock()
+				state.Done[i] = true
+				err = savePartState(target, state)
+				mu.Unlock()
+				if err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+
+send:
+	for i, done := range state.Done {
+		if done {
+			continue
+		}
+		select {
+		case segs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(segs)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	return part.Close()
+}
+
+func (d *segmentedDownloader) fetchSegment(ctx context.Context, fileUrl string, part *os.File, start, end int64, fp *fileProgress) error {
+	req := util.MustRequest(ctx, http.MethodGet, fileUrl, nil, map[string]string{
+		"Range":      fmt.Sprintf("bytes=%d-%d", start, end),
+		"User-Agent": util.UserAgent,
+	})
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return &util.HttpError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	var written atomic.Int64
+	n, err := io.Copy(io.NewOffsetWriter(part, start), &countingReader{r: resp.Body, n: &written, total: &fp.done})
+	if err == nil && n != end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// 失敗した分はやり直すので戻す
+		fp.done.Add(-written.Load())
+		return err
+	}
+
+	return nil
+}
+
+func segmentRange(i int, size int64) (int64, int64) {
+	start := int64(i) * segmentSize
+	return start, min(start+segmentSize, size) - 1
+}
+
+func loadPartState(target string, size int64) *partState {
+	segments := int((size + segmentSize - 1) / segmentSize)
+	fresh := &partState{Size: size, Done: make([]bool, segments)}
+
+	if _, err := os.Stat(target + partSuffix); err != nil {
+		return fresh
+	}
+	byts, err := os.ReadFile(target + partStateSuffix)
+	if err != nil {
+		return fresh
+	}
+	state, err := util.Unmarshal[partState](byts)
+	if err != nil || state.Size != size || len(state.Done) != segments {
+		return fresh
+	}
+
+	return state
+}
+
+func savePartState(target string, state *partState) error {
+	return os.WriteFile(target+partStateSuffix, util.MustMarshal(state), 0777)
+}
+
+func safeFilename(name string) (string, error) {
+	base := filepath.Base(path.Clean("/" + name))
+	if base == "" || base == "." || base == "/" || base == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid filename %q", name)
+	}
+	return base, nil
+}
+
+// 期限切れのURLなどはリトライしても回復しない
+func isRetryableDownload(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// 通信エラーや429/5xxはRetryTransportで再試行済み、それ以外のステータスは回復しない
+	var httpErr *util.HttpError
+	if util.RetriedByTransport(err) || errors.As(err, &httpErr) {
+		return false
+	}
+
+	// 本文の読み込み途中で切れたものは続きから取り直す
+	return true
+}
+
+type countingReader struct {
+	r     io.Reader
+	n     *atomic.Int64
+	total *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	if c.total != nil {
+		c.total.Add(int64(n))
+	}
+	return n, err
+}
@@ -3,11 +3,13 @@ package infraui
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/skratchdot/open-golang/open"
@@ -26,16 +28,54 @@ import (
 //go:embed all:frontend/dist
 var assets embed.FS
 
+// panicで終わったジョブは失敗として履歴に残す
+var errPanicked = errors.New("unexpected panic")
+
+type (
+	downloadOptions struct {
+		Rendition      string   `json:"rendition"`
+		Strategy       string   `json:"strategy"`
+		BurstPicksOnly bool     `json:"burstPicksOnly"`
+		ExcludeClasses []string `json:"excludeClasses"`
+		PathTemplate   string   `json:"pathTemplate"`
+	}
+	progressEvent struct {
+		appctx.ProgressEvent
+		Paused bool `json:"paused"`
+	}
+	jobEvent struct {
+		appctx.Job
+		Message string `json:"message,omitempty"`
+	}
+	downloadMode int
+)
+
+const (
+	// 途中で終了しても次回起動時に再開できるようにしておく
+	downloadAll downloadMode = iota
+	downloadRetry
+	// 終わってもフォルダを開かない
+	downloadScheduled
+)
+
 type app struct {
+	// 起動時のcontext、呼び出しごとのcontextはここから作る
 	ctx   context.Context
 	ucase usecase.UseCase
-	id    string
+	jobs  *jobRegistry
+
+	idMu sync.Mutex
+	id   string
+
+	scheduleMu     sync.Mutex
+	scheduleCancel context.CancelFunc
 }
 
 func NewApp(ucase usecase.UseCase) *app {
 	return &app{
 		ucase: ucase,
 		ctx:   appctx.NewAppContext(),
+		jobs:  newJobRegistry(),
 	}
 }
 
@@ -62,6 +102,23 @@ func (a *app) attachMenu(wailsApp *application.Application) {
 		})
 	}
 
+	// 時間帯ごとの制限は画面で設定する
+	bandwidthMenu := appMenu.AddSubmenu("Bandwidth")
+	limits := []int64{0, 1 << 20, 5 << 20, 10 << 20, 20 << 20, 50 << 20}
+	for i, limit := range limits {
+		label := "Unlimited"
+		if limit > 0 {
+			label = fmt.Sprintf("%d MB/s", limit>>20)
+		}
+		bandwidthMenu.AddRadio(label, config.Bandwidth.LimitBytes == limit, nil, func(cd *menu.CallbackData) {
+			appctx.CacheConfig(func(cf *appctx.ConfigFile) { cf.Bandwidth.LimitBytes = limit })
+			for j := range limits {
+				bandwidthMenu.Items[j].SetChecked(i == j)
+			}
+			wailsApp.SetApplicationMenu(appMenu)
+		})
+	}
+
 	wailsApp.SetApplicationMenu(appMenu)
 }
 
@@ -78,6 +135,10 @@ func (a *app) Run() error {
 		Bind:             []any{a},
 		OnStartup: func(ctx context.Context) {
 			a.ctx = ctx
+			a.startScheduler()
+			if err := appctx.NetworkConfigError(); err != nil {
+				go warningDialog(ctx, "app_config.jsonのNetworkの設定が間違っているため接続できません。設定を直して再起動してください。("+err.Error()+")")
+			}
 		},
 		OnShutdown: func(ctx context.Context) {
 			routines := runtime.NumGoroutine()
@@ -90,31 +151,39 @@ func (a *app) Run() error {
 	return myapp.Run()
 }
 
-func (a *app) before(id string) {
-	a.ctx = util.ContextChain(
-		a.ctx,
-		appctx.WithRequestId,
-		appctx.WithCacheCookies,
-		appctx.WithCacheConfig,
-	)
+// 呼び出しごとに起動時のcontextから新しく作る
+func (a *app) before(id string) context.Context {
+	a.idMu.Lock()
 	if id != "" {
 		a.id = id
-		a.ctx = appctx.WithUser(a.ctx, appctx.ContextUser{ID: id})
 	} else {
-		a.ctx = appctx.WithUser(a.ctx, appctx.ContextUser{ID: a.id})
+		id = a.id
 	}
+	a.idMu.Unlock()
+
+	return requestContext(a.ctx, id)
+}
+
+func requestContext(ctx context.Context, userID string) context.Context {
+	ctx = util.ContextChain(
+		ctx,
+		appctx.WithRequestId,
+		appctx.WithCacheCookies,
+		appctx.WithCacheConfig,
+	)
+	return appctx.WithUser(ctx, appctx.ContextUser{ID: userID})
 }
 
 func (a *app) LoginICloud(username, password string) string {
-	a.before(util.Hash(username + password))
+	ctx := a.before(util.Hash(username + password))
 
-	appctx.AppTrace(a.ctx)
-	defer appctx.DeferAppTrace(a.ctx)
-	defer panicTrace(a.ctx)
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
 
-	result, err := a.ucase.Login(a.ctx, username, password)
+	result, err := a.ucase.Login(ctx, username, password)
 	if err != nil {
-		slog.ErrorContext(a.ctx, err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		return util.MustJsonString(map[string]any{"error": true})
 	}
 
@@ -122,64 +191,280 @@ func (a *app) LoginICloud(username, password string) string {
 }
 
 func (a *app) Code2fa(code string) string {
-	a.before("")
+	ctx := a.before("")
 
-	appctx.AppTrace(a.ctx)
-	defer appctx.DeferAppTrace(a.ctx)
-	defer panicTrace(a.ctx)
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
 
-	if err := a.ucase.Code2fa(a.ctx, code); err != nil {
-		slog.ErrorContext(a.ctx, err.Error())
+	if err := a.ucase.Code2fa(ctx, code); err != nil {
+		slog.ErrorContext(ctx, err.Error())
 		return util.MustJsonString(false)
 	}
 
 	return util.MustJsonString(true)
 }
 
-func (a *app) AllDownloadPhotos(path string) string {
-	a.before("")
-	ticker := time.NewTicker(time.Second)
-	a.ctx = appctx.WithProgress(a.ctx)
-	appctx.AppTrace(a.ctx)
-	p, ok := appctx.Progress(a.ctx)
+func (a *app) AllDownloadPhotos(path, options string) string {
+	ctx := a.before("")
+	_, message := a.runDownload(ctx, path, options, downloadAll, a.ucase.DownloadAllPhotos)
+	return message
+}
+
+// 前回の実行で失敗した写真だけをダウンロードし直す
+func (a *app) RetryFailedPhotos(path, options string) string {
+	ctx := a.before("")
+	_, message := a.runDownload(ctx, path, options, downloadRetry, a.ucase.RetryFailedPhotos)
+	return message
+}
+
+// 再試行できる写真の数、画面を開き直した後もボタンを出せるようにファイルから数える
+func (a *app) RetryCount(path string) string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	count, err := a.ucase.RetryCount(ctx, path)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return util.MustJsonString(0)
+	}
+	return util.MustJsonString(count)
+}
+
+// ジョブを開始したらすぐに戻り、終わったらapp_jobEventで結果を知らせる
+func (a *app) runDownload(
+	ctx context.Context,
+	path, options string,
+	mode downloadMode,
+	download func(ctx context.Context, dir string, opts usecase.DownloadOptions) error,
+) (*job, string) {
+	opts, err := parseDownloadOptions(options)
+	if err != nil {
+		return nil, "失敗しました。(" + err.Error() + ")"
+	}
+	kind := appctx.JobDownload
+	if mode == downloadScheduled {
+		kind = appctx.JobSync
+	}
+	job, ctx, ok := a.jobs.start(appctx.WithProgress(ctx), kind, path)
+	if !ok {
+		return nil, "ダウンロード中です。"
+	}
+	if mode == downloadAll {
+		appctx.CacheConfig(func(cf *appctx.ConfigFile) {
+			cf.PendingJob = &appctx.PendingJob{Dir: path, Options: options}
+		})
+	}
+	wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{Job: job.snapshot()}))
+
+	go func() {
+		message, err := "失敗しました。(予期しないエラー)", errPanicked
+		defer func() {
+			wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{
+				Job:     a.jobs.finish(job, err),
+				Message: message,
+			}))
+		}()
+		defer panicTrace(ctx)
+
+		message, err = a.download(ctx, job, path, *opts, mode, download)
+	}()
+
+	return job, ""
+}
+
+func (a *app) download(
+	ctx context.Context,
+	job *job,
+	path string,
+	opts usecase.DownloadOptions,
+	mode downloadMode,
+	download func(ctx context.Context, dir string, opts usecase.DownloadOptions) error,
+) (string, error) {
+	pauser := job.pauser
+	ticker := time.NewTicker(500 * time.Millisecond)
+	appctx.AppTrace(ctx)
+	p, ok := appctx.Progress(ctx)
 
 	defer func() {
-		panicTrace(a.ctx)
 		p.Close()
-		appctx.DeferAppTrace(a.ctx)
+		appctx.DeferAppTrace(ctx)
 		ticker.Stop()
-		wailsruntime.EventsEmit(a.ctx, "app_progressEvent", 1)
+		wailsruntime.EventsEmit(ctx, "app_progressEvent", 1)
 	}()
 
 	if ok {
-		valueCh := p.Value()
+		events := p.Events()
+		fileEvents := p.FileEvents()
 		go func() {
-			for v := range valueCh {
-				wailsruntime.EventsEmit(a.ctx, "app_progressEvent", util.MustJsonString(map[string]any{
-					"value": v,
-					"phase": p.Phase(),
-				}))
+			for status := range fileEvents {
+				wailsruntime.EventsEmit(ctx, "app_fileEvent", util.MustJsonString(status))
 			}
 		}()
+		go func() {
+			// 画面への通知はtickerの間隔にまとめる
+			var (
+				latest appctx.ProgressEvent
+				dirty  bool
+				paused bool
+			)
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return
+					}
+					latest, dirty = e, true
+				case <-ticker.C:
+					// 取りこぼした場合は画面でFileStatusesから読み直してもらう
+					if p.TakeFileEventsDropped() {
+						wailsruntime.EventsEmit(ctx, "app_fileReload")
+					}
+					if !dirty && paused == pauser.Paused() {
+						continue
+					}
+					paused, dirty = pauser.Paused(), false
+					wailsruntime.EventsEmit(ctx, "app_progressEvent", util.MustJsonString(progressEvent{
+						ProgressEvent: latest,
+						Paused:        paused,
+					}))
+				}
+			}
+		}()
+	}
+
+	err := download(ctx, path, opts)
+	switch {
+	case errors.Is(err, usecase.ErrCanceled):
+		slog.InfoContext(ctx, "Download stopped")
+		return "停止しました。", err
+	case errors.Is(err, usecase.ErrSessionExpired):
+		slog.ErrorContext(ctx, err.Error())
+		notifySessionExpired(ctx)
+		return "ログインの有効期限が切れました。ログインし直してください。", err
+	case errors.Is(err, usecase.ErrSomeFailed):
+		// 一覧は最後まで取れているので、残りは再試行に任せる
+		slog.WarnContext(ctx, err.Error())
+		if mode == downloadAll {
+			appctx.CacheConfig(func(cf *appctx.ConfigFile) { cf.PendingJob = nil })
+		}
+		return "一部失敗しました。(" + err.Error() + ") 「失敗したものを再試行」で再試行できます。", err
+	case err != nil:
+		slog.ErrorContext(ctx, err.Error())
+		return "失敗しました。(" + err.Error() + ")", err
+	}
+
+	if mode == downloadAll {
+		appctx.CacheConfig(func(cf *appctx.ConfigFile) { cf.PendingJob = nil })
+	}
+	if mode != downloadScheduled {
+		open.Start(path)
+	}
+	return "", nil
+}
+
+// 実行中のファイルは最後まで落とし、次のファイルから止める
+func (a *app) Pause() bool {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	job := a.jobs.current(appctx.JobDownload)
+	if job == nil {
+		return false
+	}
+	job.pauser.Pause()
+	return true
+}
+
+func (a *app) Resume() bool {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	job := a.jobs.current(appctx.JobDownload)
+	if job == nil {
+		return false
+	}
+	job.pauser.Resume()
+	return true
+}
+
+// 実行中か最後に実行したダウンロードのファイルごとの状態、完了したものは含まない
+func (a *app) FileStatuses() string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	job := a.jobs.lastJob(appctx.JobDownload)
+	if job == nil || job.progress == nil {
+		return util.MustJsonString([]appctx.FileStatus{})
+	}
+	return util.MustJsonString(job.progress.FileStatuses())
+}
+
+// 実行中のものを含めたジョブの履歴、新しいものから
+func (a *app) Jobs() string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	jobs, err := a.jobs.history()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return util.MustJsonString([]appctx.Job{})
 	}
+	return util.MustJsonString(jobs)
+}
+
+// 前回終わらなかったダウンロードがあれば返す
+func (a *app) PendingJob() string {
+	ctx := a.before("")
 
-	if err := a.ucase.DownloadAllPhotos(a.ctx, path); err != nil {
-		slog.ErrorContext(a.ctx, err.Error())
-		return "失敗しました。(" + err.Error() + ")"
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	job := appctx.Config(context.TODO()).PendingJob
+	if job == nil {
+		return ""
 	}
+	return util.MustJsonString(map[string]any{
+		"dir":     job.Dir,
+		"options": job.Options,
+	})
+}
 
-	open.Start(path)
-	return ""
+// 実行中のダウンロードだけを止める、セッションは残る
+func (a *app) Stop() {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	a.jobs.cancel(appctx.JobDownload)
 }
 
 func (a *app) SelectDirectory() string {
-	a.before("")
+	ctx := a.before("")
 
-	appctx.AppTrace(a.ctx)
-	defer appctx.DeferAppTrace(a.ctx)
-	defer panicTrace(a.ctx)
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
 
-	dir, err := wailsruntime.OpenDirectoryDialog(a.ctx, wailsruntime.OpenDialogOptions{})
+	dir, err := wailsruntime.OpenDirectoryDialog(ctx, wailsruntime.OpenDialogOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -188,13 +473,55 @@ func (a *app) SelectDirectory() string {
 }
 
 func (a *app) Cancel() {
-	a.before("")
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	select {
+	case <-a.jobs.cancelAll():
+	case <-time.After(5 * time.Second):
+	}
+	wailsruntime.Quit(ctx)
+}
 
-	appctx.AppTrace(a.ctx)
-	defer appctx.DeferAppTrace(a.ctx)
-	defer panicTrace(a.ctx)
+func parseDownloadOptions(options string) (*usecase.DownloadOptions, error) {
+	opts, err := util.Unmarshal[downloadOptions]([]byte(options))
+	if err != nil {
+		return nil, err
+	}
+
+	rendition, err := usecase.ParseRendition(opts.Rendition)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := usecase.ParseStrategy(opts.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var excludeClasses []usecase.MediaClass
+	for _, c := range opts.ExcludeClasses {
+		class, err := usecase.ParseMediaClass(c)
+		if err != nil {
+			return nil, err
+		}
+		excludeClasses = append(excludeClasses, class)
+	}
+
+	if err := usecase.ValidatePathTemplate(opts.PathTemplate); err != nil {
+		return nil, err
+	}
 
-	wailsruntime.Quit(a.ctx)
+	return &usecase.DownloadOptions{
+		Rendition:      rendition,
+		Strategy:       strategy,
+		BurstPicksOnly: opts.BurstPicksOnly,
+		ExcludeClasses: excludeClasses,
+		PathTemplate:   opts.PathTemplate,
+	}, nil
 }
 
 func panicTrace(ctx context.Context) {
@@ -0,0 +1,56 @@
+package infraui
+
+import (
+	"context"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+// 時間帯ごとの帯域制限、時間帯の外はメニューで選んだ制限になる
+func (a *app) BandwidthSchedule() string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	conf := appctx.Config(context.TODO()).Bandwidth
+	windows := make([]map[string]any, 0, len(conf.Schedule))
+	for _, w := range conf.Schedule {
+		windows = append(windows, map[string]any{
+			"start":      w.Start,
+			"end":        w.End,
+			"limitBytes": w.LimitBytes,
+		})
+	}
+	return util.MustJsonString(map[string]any{
+		"useSchedule": conf.UseSchedule,
+		"windows":     windows,
+	})
+}
+
+// windowsは[{"start":"09:00","end":"18:00","limitBytes":1048576}]の形式
+func (a *app) SetBandwidthSchedule(useSchedule bool, windows string) string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	schedule, err := util.Unmarshal[[]appctx.BandwidthWindow]([]byte(windows))
+	if err != nil {
+		return "失敗しました。(" + err.Error() + ")"
+	}
+	for _, w := range *schedule {
+		if err := w.Validate(); err != nil {
+			return "失敗しました。(" + err.Error() + ")"
+		}
+	}
+
+	appctx.CacheConfig(func(cf *appctx.ConfigFile) {
+		cf.Bandwidth.UseSchedule = useSchedule
+		cf.Bandwidth.Schedule = *schedule
+	})
+	return ""
+}
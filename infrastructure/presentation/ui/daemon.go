@@ -0,0 +1,75 @@
+package infraui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+// 画面を出さずに設定のスケジュールでダウンロードを続ける、ctxが終わるまで戻らない
+func RunDaemon(ctx context.Context, ucase usecase.UseCase) error {
+	conf := appctx.Config(ctx).Schedule
+	if !conf.Enabled {
+		return errors.New("schedule is not enabled")
+	}
+	schedule, err := scheduleOf(conf)
+	if err != nil {
+		return err
+	}
+	opts, err := parseDownloadOptions(conf.Options)
+	if err != nil {
+		return err
+	}
+
+	// 止めるまでロックを持ち、GUIの定期ダウンロードを止めておく
+	unlock, err := appctx.LockSchedule()
+	if err != nil {
+		return fmt.Errorf("another scheduled download is running: %w", err)
+	}
+	defer unlock()
+
+	jobs := newJobRegistry()
+	slog.InfoContext(ctx, "Daemon started", slog.String("dir", conf.Dir))
+	util.RunSchedule(ctx, schedule, func(ctx context.Context) {
+		ctx = requestContext(ctx, conf.UserID)
+		job, jobCtx, ok := jobs.start(appctx.WithProgress(ctx), appctx.JobSync, conf.Dir)
+		if !ok {
+			slog.WarnContext(ctx, "Skip scheduled download", slog.String("reason", "previous download is still running"))
+			return
+		}
+		ctx = jobCtx
+
+		appctx.AppTrace(ctx)
+		defer appctx.DeferAppTrace(ctx)
+
+		// panicした場合も失敗として履歴に残す
+		err := errPanicked
+		defer func() {
+			record := jobs.finish(job, err)
+			switch {
+			case errors.Is(err, usecase.ErrSessionExpired):
+				slog.ErrorContext(ctx, err.Error())
+				if err := util.Notify("iCloud Photos Downloader", sessionExpiredMessage); err != nil {
+					slog.WarnContext(ctx, "Failed to notify", slog.String("error", err.Error()))
+				}
+			case err != nil:
+				slog.ErrorContext(ctx, err.Error(), slog.String("job", record.ID))
+			default:
+				slog.InfoContext(ctx, "Scheduled download finished", slog.String("job", record.ID))
+			}
+		}()
+		defer panicTrace(ctx)
+
+		err = ucase.ScheduledDownload(ctx, conf.Dir, *opts)
+		if p, ok := appctx.Progress(ctx); ok {
+			p.Close()
+		}
+	})
+
+	return nil
+}
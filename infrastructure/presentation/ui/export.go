@@ -0,0 +1,92 @@
+package infraui
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const exportFilename = "manifest.csv"
+
+// ダウンロード済みの一覧を選んだファイルに書き出す、結果はapp_jobEventで知らせる
+func (a *app) ExportManifest(path string) string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	dest, err := wailsruntime.SaveFileDialog(ctx, wailsruntime.SaveDialogOptions{
+		DefaultDirectory: path,
+		DefaultFilename:  exportFilename,
+		Filters:          []wailsruntime.FileFilter{{DisplayName: "CSV", Pattern: "*.csv"}},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return "失敗しました。(" + err.Error() + ")"
+	}
+	// 選ばずに閉じた場合
+	if dest == "" {
+		return ""
+	}
+
+	job, ctx, ok := a.jobs.start(ctx, appctx.JobExport, path)
+	if !ok {
+		return "書き出し中です。"
+	}
+	wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{Job: job.snapshot()}))
+
+	go func() {
+		message, err := "失敗しました。(予期しないエラー)", errPanicked
+		defer func() {
+			wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{
+				Job:     a.jobs.finish(job, err),
+				Message: message,
+			}))
+		}()
+		defer panicTrace(ctx)
+
+		message, err = export(ctx, a.ucase, path, dest)
+	}()
+
+	return ""
+}
+
+// 画面を出さずに書き出して結果を履歴に残す
+func RunExport(ctx context.Context, ucase usecase.UseCase, dir, dest string) (appctx.Job, error) {
+	jobs := newJobRegistry()
+	job, ctx, ok := jobs.start(ctx, appctx.JobExport, dir)
+	if !ok {
+		return appctx.Job{}, errors.New("export is already running")
+	}
+
+	// panicした場合も失敗として残す
+	err := errPanicked
+	defer func() {
+		jobs.finish(job, err)
+	}()
+	_, err = export(ctx, ucase, dir, dest)
+
+	return jobs.finish(job, err), err
+}
+
+func export(ctx context.Context, ucase usecase.UseCase, dir, dest string) (string, error) {
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+
+	err := ucase.ExportManifest(ctx, dir, dest)
+	switch {
+	case errors.Is(err, usecase.ErrCanceled):
+		slog.InfoContext(ctx, "Export stopped")
+		return "停止しました。", err
+	case err != nil:
+		slog.ErrorContext(ctx, err.Error())
+		return "失敗しました。(" + err.Error() + ")", err
+	}
+	return "", nil
+}
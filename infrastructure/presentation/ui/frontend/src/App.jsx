@@ -1,10 +1,16 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import { Login } from '@/src/LoginPage';
 import { Code2Fa } from '@/src/Code2FaPage';
 import { Photos } from '@/src/PhotosPage';
 
 function App() {
   const [page, setPage] = useState('login');
+
+  useEffect(() => {
+    // 定期バックアップでcookieの期限切れが分かったらログインし直してもらう
+    window.runtime.EventsOn("app_sessionExpired", () => setPage('login'));
+  }, []);
+
   return (
     <div id="App">
       {page === 'login' && (<Login setPage={setPage} />)}
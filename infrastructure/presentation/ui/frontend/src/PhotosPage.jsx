@@ -1,19 +1,119 @@
 import { useState, useEffect } from "react";
-import { SelectDirectory, AllDownloadPhotos, Cancel } from "@/wailsjs/go/infraui/App";
+import { SelectDirectory, AllDownloadPhotos, RetryCount, RetryFailedPhotos, Cancel, Stop, Pause, Resume, PendingJob, FileStatuses, Jobs, Schedule, SetSchedule, BandwidthSchedule, SetBandwidthSchedule, VerifyDownloads, ExportManifest } from "@/wailsjs/go/infraui/App";
 import { useAlert } from 'react-alert';
 
+const formatBytes = (bytes) => {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let value = bytes || 0;
+  let i = 0;
+  while (value >= 1024 && i < units.length - 1) {
+    value /= 1024;
+    i++;
+  }
+  return `${value.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+};
+
+const formatDuration = (seconds) => {
+  if (!seconds) return "-";
+  const s = Math.round(seconds);
+  const h = Math.floor(s / 3600);
+  const m = Math.floor((s % 3600) / 60);
+  return h > 0 ? `${h}時間${m}分` : m > 0 ? `${m}分${s % 60}秒` : `${s}秒`;
+};
+
 export const Photos = ({ setPage }) => {
   const [selectedDir, setSelectedDir] = useState("");
   const [isLoading, setIsLoading] = useState(false);
+  const [isStopping, setIsStopping] = useState(false);
+  const [isPaused, setIsPaused] = useState(false);
+  const [isVerifying, setIsVerifying] = useState(false);
+  const [isExporting, setIsExporting] = useState(false);
+  const [pendingJob, setPendingJob] = useState(null);
+  const [retryCount, setRetryCount] = useState(0);
+  const [retryReload, setRetryReload] = useState(0);
   const [progress, setProgress] = useState(null);
   const [phase, setPhase] = useState("");
+  const [listing, setListing] = useState({ listed: 0, listTotal: 0 });
+  const [stats, setStats] = useState(null);
+  const [files, setFiles] = useState({});
+  const [jobs, setJobs] = useState([]);
+  const [showJobs, setShowJobs] = useState(false);
+  const [schedule, setSchedule] = useState({ enabled: false, interval: "", cron: "" });
+  const [bandwidth, setBandwidth] = useState({ useSchedule: false, windows: [] });
+  const [showBandwidth, setShowBandwidth] = useState(false);
+  const [rendition, setRendition] = useState("original");
+  const [strategy, setStrategy] = useState("auto");
+  const [burstPicksOnly, setBurstPicksOnly] = useState(false);
+  const [excludeScreenshots, setExcludeScreenshots] = useState(false);
+  const [pathTemplate, setPathTemplate] = useState("{burst}/{filename}");
   const alert = useAlert();
 
   useEffect(() => {
     window.runtime.EventsOn("app_progressEvent", (value) => {
       const progress = JSON.parse(value);
+      if (typeof progress !== "object") return;
       setProgress(Math.floor(progress.value * 10000) / 100);
+      setStats(progress);
       setPhase(progress.phase);
+      setListing({ listed: progress.listed, listTotal: progress.listTotal });
+      setIsPaused(progress.paused);
+    });
+    window.runtime.EventsOn("app_fileEvent", (value) => {
+      const status = JSON.parse(value);
+      // 完了したものは件数だけprogressEventで受け取る
+      setFiles((prev) => {
+        const { [status.key]: _, ...rest } = prev;
+        return status.state === "done" ? rest : { ...rest, [status.key]: status };
+      });
+    });
+    window.runtime.EventsOn("app_fileReload", () => loadFileStatuses());
+    window.runtime.EventsOn("app_jobEvent", (value) => {
+      const job = JSON.parse(value);
+      loadJobs();
+      if (job.status === "running") {
+        // 定期ダウンロードは画面の操作なしで始まる
+        if (job.kind === "sync") setIsLoading(true);
+        return;
+      }
+      if (job.kind === "export") {
+        setIsExporting(false);
+        if (job.message) {
+          alert.error(job.message);
+          return;
+        }
+        alert.info('一覧を書き出しました');
+        return;
+      }
+      if (job.kind === "verify") {
+        setIsVerifying(false);
+        if (job.message) {
+          alert.error(job.message);
+          return;
+        }
+        alert.info('すべてのファイルを確認しました');
+        return;
+      }
+      setIsLoading(false);
+      setIsStopping(false);
+      setIsPaused(false);
+      loadFileStatuses();
+      setRetryReload((n) => n + 1);
+      if (job.message) {
+        alert.error(job.message);
+        return;
+      }
+      alert.info('完了');
+    });
+    Schedule().then((value) => setSchedule(JSON.parse(value)));
+    BandwidthSchedule().then((value) => setBandwidth(JSON.parse(value)));
+    PendingJob().then((job) => {
+      if (job) setPendingJob(JSON.parse(job));
+    });
+    loadJobs().then((history) => {
+      // 画面を開き直した場合も実行中のジョブを引き継ぐ
+      if (history.some((job) => ["download", "sync"].includes(job.kind) && job.status === "running")) {
+        setIsLoading(true);
+      }
     });
   }, []);
 
@@ -24,22 +124,127 @@ export const Photos = ({ setPage }) => {
     }
   };
 
-  const download = async () => {
-    if (!selectedDir) return;
+  // 再試行の一覧はダウンロード先ごとにファイルに残っているので、ジョブが終わるたびに数え直す
+  useEffect(() => {
+    if (!selectedDir) {
+      setRetryCount(0);
+      return;
+    }
+    RetryCount(selectedDir).then((value) => setRetryCount(JSON.parse(value)));
+  }, [selectedDir, retryReload]);
+
+  const loadJobs = async () => {
+    const history = JSON.parse(await Jobs());
+    setJobs(history);
+    return history;
+  };
+
+  const loadFileStatuses = async () => {
+    const statuses = JSON.parse(await FileStatuses());
+    setFiles(Object.fromEntries(statuses.map((status) => [status.key, status])));
+  };
+
+  const run = async (dir, options, action = AllDownloadPhotos) => {
     setProgress(0);
+    setFiles({});
     setIsLoading(true);
-    try {
-      const errorMessage = await AllDownloadPhotos(selectedDir);
-      if (errorMessage) {
-        alert.error(errorMessage);
-        return;
-      }
-      alert.info('完了');
-    } finally {
+    setPendingJob(null);
+    // 開始できた場合の結果はapp_jobEventで受け取る
+    const errorMessage = await action(dir, options);
+    if (errorMessage) {
+      alert.error(errorMessage);
       setIsLoading(false);
     }
   };
 
+  const downloadOptions = () => JSON.stringify({
+    rendition,
+    strategy,
+    burstPicksOnly,
+    excludeClasses: excludeScreenshots ? ["screenshot"] : [],
+    pathTemplate,
+  });
+
+  const download = async () => {
+    if (!selectedDir) return;
+    await run(selectedDir, downloadOptions());
+  };
+
+  const retryFailed = async () => {
+    if (!selectedDir) return;
+    await run(selectedDir, downloadOptions(), RetryFailedPhotos);
+  };
+
+  const verify = async () => {
+    if (!selectedDir) return;
+    setIsVerifying(true);
+    const errorMessage = await VerifyDownloads(selectedDir);
+    if (errorMessage) {
+      alert.error(errorMessage);
+      setIsVerifying(false);
+    }
+  };
+
+  const exportManifest = async () => {
+    if (!selectedDir) return;
+    setIsExporting(true);
+    const errorMessage = await ExportManifest(selectedDir);
+    if (errorMessage) {
+      alert.error(errorMessage);
+      setIsExporting(false);
+    }
+  };
+
+  const resumePending = async () => {
+    if (!pendingJob) return;
+    await run(pendingJob.dir, pendingJob.options);
+  };
+
+  const changeSchedule = async (value) => {
+    // cronはapp_config.jsonで設定されたものをそのまま使う
+    const interval = value === "cron" ? "" : value;
+    const cron = value === "cron" ? schedule.cron : "";
+    const errorMessage = await SetSchedule(selectedDir || schedule.dir || "", downloadOptions(), interval, cron);
+    if (errorMessage) {
+      alert.error(errorMessage);
+      return;
+    }
+    setSchedule(JSON.parse(await Schedule()));
+  };
+
+  const updateWindow = (i, patch) => {
+    setBandwidth((prev) => ({
+      ...prev,
+      windows: prev.windows.map((w, j) => (i === j ? { ...w, ...patch } : w)),
+    }));
+  };
+
+  const saveBandwidth = async () => {
+    const errorMessage = await SetBandwidthSchedule(bandwidth.useSchedule, JSON.stringify(bandwidth.windows));
+    if (errorMessage) {
+      alert.error(errorMessage);
+      return;
+    }
+    setBandwidth(JSON.parse(await BandwidthSchedule()));
+    alert.info('保存しました');
+  };
+
+  const togglePause = async () => {
+    if (isPaused) {
+      if (await Resume()) setIsPaused(false);
+    } else {
+      if (await Pause()) setIsPaused(true);
+    }
+  };
+
+  const fileList = Object.values(files);
+  const failedFiles = fileList.filter((f) => f.state === "failed");
+
+  const stop = async () => {
+    setIsStopping(true);
+    await Stop();
+  };
+
   return (
     <div style={{
       display: "flex",
@@ -93,13 +298,163 @@ export const Photos = ({ setPage }) => {
             {selectedDir}
           </p>
         )}
-        {phase === 'CHECK_FILES' && (
+        <select
+          style={{
+            width: "100%",
+            marginTop: "12px",
+            padding: "8px",
+            borderRadius: "8px",
+            backgroundColor: "#333",
+            color: "white",
+            border: "none",
+          }}
+          value={rendition}
+          onChange={(e) => setRendition(e.target.value)}
+          disabled={isLoading}
+        >
+          <option value="original">オリジナル</option>
+          <option value="compatible">互換性優先 (JPEG最大)</option>
+          <option value="medium">プレビュー (中サイズ)</option>
+          <option value="small_video">動画を最小サイズ</option>
+        </select>
+
+        <select
+          style={{
+            width: "100%",
+            marginTop: "8px",
+            padding: "8px",
+            borderRadius: "8px",
+            backgroundColor: "#333",
+            color: "white",
+            border: "none",
+          }}
+          value={strategy}
+          onChange={(e) => setStrategy(e.target.value)}
+          disabled={isLoading}
+        >
+          <option value="auto">自動 (サイズと種類で選択)</option>
+          <option value="zip">zipでまとめて</option>
+          <option value="direct">1ファイルずつ</option>
+        </select>
+
+        <label style={{ display: "block", marginTop: "12px", fontSize: "14px", textAlign: "left" }}>
+          <input
+            type="checkbox"
+            checked={burstPicksOnly}
+            onChange={(e) => setBurstPicksOnly(e.target.checked)}
+            disabled={isLoading}
+          />
+          バーストは選択された写真のみ
+        </label>
+
+        <label style={{ display: "block", marginTop: "8px", fontSize: "14px", textAlign: "left" }}>
+          <input
+            type="checkbox"
+            checked={excludeScreenshots}
+            onChange={(e) => setExcludeScreenshots(e.target.checked)}
+            disabled={isLoading}
+          />
+          スクリーンショットを除外
+        </label>
+
+        <input
+          style={{
+            width: "100%",
+            marginTop: "8px",
+            padding: "8px",
+            borderRadius: "8px",
+            backgroundColor: "#333",
+            color: "white",
+            border: "none",
+            boxSizing: "border-box",
+          }}
+          value={pathTemplate}
+          onChange={(e) => setPathTemplate(e.target.value)}
+          placeholder="{class}/{burst}/{filename}"
+          disabled={isLoading}
+        />
+
+        <select
+          style={{
+            width: "100%",
+            marginTop: "8px",
+            padding: "8px",
+            borderRadius: "8px",
+            backgroundColor: "#333",
+            color: "white",
+            border: "none",
+          }}
+          value={!schedule.enabled ? "" : schedule.cron ? "cron" : schedule.interval}
+          onChange={(e) => changeSchedule(e.target.value)}
+          disabled={!selectedDir && !schedule.dir}
+          title={schedule.dir}
+        >
+          <option value="">定期バックアップしない</option>
+          <option value="1h">1時間ごとにバックアップ</option>
+          <option value="6h">6時間ごとにバックアップ</option>
+          <option value="24h">毎日バックアップ</option>
+          {schedule.cron && <option value="cron">cron ({schedule.cron})</option>}
+        </select>
+
+        <button
+          style={{
+            width: "100%",
+            marginTop: "8px",
+            backgroundColor: "#333",
+            color: "white",
+            padding: "8px",
+            borderRadius: "8px",
+            border: "none",
+            cursor: "pointer",
+          }}
+          onClick={() => setShowBandwidth(!showBandwidth)}
+        >
+          {showBandwidth ? "帯域制限の時間帯を閉じる" : "帯域制限の時間帯"}
+        </button>
+
+        {showBandwidth && (
+          <div style={{ marginTop: "8px", textAlign: "left", fontSize: "12px" }}>
+            <label style={{ display: "block" }}>
+              <input
+                type="checkbox"
+                checked={bandwidth.useSchedule}
+                onChange={(e) => setBandwidth({ ...bandwidth, useSchedule: e.target.checked })}
+              />
+              時間帯で制限する (時間外はメニューの設定)
+            </label>
+            {bandwidth.windows.map((w, i) => (
+              <div key={i} style={{ display: "flex", gap: "4px", marginTop: "4px", alignItems: "center" }}>
+                <input type="time" value={w.start} onChange={(e) => updateWindow(i, { start: e.target.value })} />
+                〜
+                <input type="time" value={w.end} onChange={(e) => updateWindow(i, { end: e.target.value })} />
+                <input
+                  type="number"
+                  min="0"
+                  style={{ width: "48px" }}
+                  value={w.limitBytes / (1 << 20)}
+                  onChange={(e) => updateWindow(i, { limitBytes: Math.max(0, Math.round(Number(e.target.value) * (1 << 20))) })}
+                  title="0で無制限"
+                />
+                MB/s
+                <button onClick={() => setBandwidth({ ...bandwidth, windows: bandwidth.windows.filter((_, j) => j !== i) })}>削除</button>
+              </div>
+            ))}
+            <div style={{ display: "flex", gap: "8px", marginTop: "8px" }}>
+              <button onClick={() => setBandwidth({ ...bandwidth, windows: [...bandwidth.windows, { start: "09:00", end: "18:00", limitBytes: 1 << 20 }] })}>
+                追加
+              </button>
+              <button onClick={saveBandwidth}>保存</button>
+            </div>
+          </div>
+        )}
+
+        {['DOWNLOAD', 'DOWNLOAD_ZIP', 'DOWNLOAD_DIRECT'].includes(phase) && listing.listed < listing.listTotal && (
           <div>
             <p>ICloudのファイルを確認しています...</p>
-            <p>ファイル数: {progress/100}</p>
+            <p>ファイル数: {listing.listed} / {listing.listTotal}</p>
           </div>
         )}
-        {['DOWNLOAD_DUPLICATE', 'DOWNLOAD_ZIP'].includes(phase) && (
+        {['DOWNLOAD', 'DOWNLOAD_ZIP', 'DOWNLOAD_DIRECT'].includes(phase) && (
           <>
             <div style={{
               marginTop: "12px",
@@ -129,6 +484,18 @@ export const Photos = ({ setPage }) => {
             </div>
             <p>phase: {phase}</p>
             {progress}%
+            {stats && stats.files && (
+              <p style={{ fontSize: "12px" }}>
+                待機 {stats.files.queued} / 取得中 {stats.files.active} / 完了 {stats.files.done} / 失敗 {stats.files.failed}
+              </p>
+            )}
+            {stats && (
+              <div style={{ fontSize: "12px", marginTop: "8px" }}>
+                <p>{Math.floor(stats.filesDone)} / {stats.filesTotal} ファイル</p>
+                <p>{formatBytes(stats.bytesDone)} / {formatBytes(stats.bytesTotal)}</p>
+                <p>{formatBytes(stats.bytesPerSec)}/s 残り {formatDuration(stats.etaSeconds)}</p>
+              </div>
+            )}
           </>
         )}
 
@@ -159,6 +526,170 @@ export const Photos = ({ setPage }) => {
           {isLoading ? "ダウンロード中..." : "ダウンロード開始"}
         </button>
 
+        {failedFiles.length > 0 && (
+          <div style={{ marginTop: "12px", textAlign: "left", fontSize: "12px", maxHeight: "120px", overflowY: "auto" }}>
+            <p style={{ fontWeight: "bold" }}>失敗したファイル</p>
+            {failedFiles.map((f) => (
+              <p key={f.key} title={f.reason}>{f.filename || f.key}: {f.reason}</p>
+            ))}
+          </div>
+        )}
+
+        {!isLoading && selectedDir && (retryCount > 0 || failedFiles.length > 0) && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#fd7e14",
+              color: "white",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: "pointer",
+            }}
+            onClick={retryFailed}
+          >
+            失敗したものを再試行{retryCount > 0 && ` (${retryCount})`}
+          </button>
+        )}
+
+        {!isLoading && selectedDir && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#333",
+              color: "white",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: isVerifying ? "not-allowed" : "pointer",
+              opacity: isVerifying ? 0.2 : 1,
+            }}
+            onClick={verify}
+            disabled={isVerifying}
+          >
+            {isVerifying ? "確認中..." : "ダウンロード済みのファイルを確認"}
+          </button>
+        )}
+
+        {!isLoading && selectedDir && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#333",
+              color: "white",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: isExporting ? "not-allowed" : "pointer",
+              opacity: isExporting ? 0.2 : 1,
+            }}
+            onClick={exportManifest}
+            disabled={isExporting}
+          >
+            {isExporting ? "書き出し中..." : "ダウンロード済みの一覧を書き出す"}
+          </button>
+        )}
+
+        {!isLoading && pendingJob && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#17a2b8",
+              color: "white",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: "pointer",
+            }}
+            onClick={resumePending}
+            title={pendingJob.dir}
+          >
+            前回の続きから再開
+          </button>
+        )}
+
+        {isLoading && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#ffc107",
+              color: "black",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: isStopping ? "not-allowed" : "pointer",
+              opacity: isStopping ? 0.2 : 1,
+            }}
+            onClick={togglePause}
+            disabled={isStopping}
+          >
+            {isPaused ? "再開" : "一時停止"}
+          </button>
+        )}
+
+        {isLoading && (
+          <button
+            style={{
+              width: "100%",
+              marginTop: "16px",
+              backgroundColor: "#6c757d",
+              color: "white",
+              fontWeight: "bold",
+              padding: "10px",
+              borderRadius: "8px",
+              border: "none",
+              cursor: isStopping ? "not-allowed" : "pointer",
+              opacity: isStopping ? 0.2 : 1,
+            }}
+            onClick={stop}
+            disabled={isStopping}
+          >
+            {isStopping ? "停止中..." : "停止"}
+          </button>
+        )}
+
+        <button
+          style={{
+            width: "100%",
+            marginTop: "16px",
+            backgroundColor: "#333",
+            color: "white",
+            fontWeight: "bold",
+            padding: "10px",
+            borderRadius: "8px",
+            border: "none",
+            cursor: "pointer",
+          }}
+          onClick={() => {
+            if (!showJobs) loadJobs();
+            setShowJobs(!showJobs);
+          }}
+        >
+          {showJobs ? "履歴を閉じる" : "履歴"}
+        </button>
+
+        {showJobs && (
+          <div style={{ marginTop: "12px", textAlign: "left", fontSize: "12px", maxHeight: "160px", overflowY: "auto" }}>
+            {jobs.length === 0 && <p>履歴はありません</p>}
+            {jobs.map((job) => (
+              <p key={job.id} title={job.errors.join("\n")}>
+                {new Date(job.startedAt).toLocaleString()} {job.kind} {job.status} {formatBytes(job.bytesDone)}
+                {job.errors.length > 0 && ` (エラー ${job.errors.length})`}
+              </p>
+            ))}
+          </div>
+        )}
+
         <button
           style={{
             width: "100%",
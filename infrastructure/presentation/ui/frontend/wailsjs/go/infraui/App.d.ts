@@ -1,14 +1,42 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
-export function AllDownloadPhotos(arg1:string):Promise<string>;
+export function AllDownloadPhotos(arg1:string,arg2:string):Promise<string>;
+
+export function BandwidthSchedule():Promise<string>;
 
 export function Cancel():Promise<void>;
 
 export function Code2fa(arg1:string):Promise<string>;
 
+export function ExportManifest(arg1:string):Promise<string>;
+
+export function FileStatuses():Promise<string>;
+
+export function Jobs():Promise<string>;
+
 export function LoginICloud(arg1:string,arg2:string):Promise<string>;
 
+export function Pause():Promise<boolean>;
+
+export function PendingJob():Promise<string>;
+
+export function Resume():Promise<boolean>;
+
+export function RetryCount(arg1:string):Promise<string>;
+
+export function RetryFailedPhotos(arg1:string,arg2:string):Promise<string>;
+
 export function Run():Promise<void>;
 
+export function Schedule():Promise<string>;
+
 export function SelectDirectory():Promise<string>;
+
+export function SetBandwidthSchedule(arg1:boolean,arg2:string):Promise<string>;
+
+export function SetSchedule(arg1:string,arg2:string,arg3:string,arg4:string):Promise<string>;
+
+export function Stop():Promise<void>;
+
+export function VerifyDownloads(arg1:string):Promise<string>;
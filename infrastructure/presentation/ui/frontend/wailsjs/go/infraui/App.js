@@ -2,8 +2,12 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
-export function AllDownloadPhotos(arg1) {
-  return window['go']['infraui']['app']['AllDownloadPhotos'](arg1);
+export function AllDownloadPhotos(arg1, arg2) {
+  return window['go']['infraui']['app']['AllDownloadPhotos'](arg1, arg2);
+}
+
+export function BandwidthSchedule() {
+  return window['go']['infraui']['app']['BandwidthSchedule']();
 }
 
 export function Cancel() {
@@ -14,14 +18,66 @@ export function Code2fa(arg1) {
   return window['go']['infraui']['app']['Code2fa'](arg1);
 }
 
+export function ExportManifest(arg1) {
+  return window['go']['infraui']['app']['ExportManifest'](arg1);
+}
+
+export function FileStatuses() {
+  return window['go']['infraui']['app']['FileStatuses']();
+}
+
+export function Jobs() {
+  return window['go']['infraui']['app']['Jobs']();
+}
+
 export function LoginICloud(arg1, arg2) {
   return window['go']['infraui']['app']['LoginICloud'](arg1, arg2);
 }
 
+export function Pause() {
+  return window['go']['infraui']['app']['Pause']();
+}
+
+export function PendingJob() {
+  return window['go']['infraui']['app']['PendingJob']();
+}
+
+export function Resume() {
+  return window['go']['infraui']['app']['Resume']();
+}
+
+export function RetryCount(arg1) {
+  return window['go']['infraui']['app']['RetryCount'](arg1);
+}
+
+export function RetryFailedPhotos(arg1, arg2) {
+  return window['go']['infraui']['app']['RetryFailedPhotos'](arg1, arg2);
+}
+
 export function Run() {
   return window['go']['infraui']['app']['Run']();
 }
 
+export function Schedule() {
+  return window['go']['infraui']['app']['Schedule']();
+}
+
 export function SelectDirectory() {
   return window['go']['infraui']['app']['SelectDirectory']();
 }
+
+export function SetBandwidthSchedule(arg1, arg2) {
+  return window['go']['infraui']['app']['SetBandwidthSchedule'](arg1, arg2);
+}
+
+export function SetSchedule(arg1, arg2, arg3, arg4) {
+  return window['go']['infraui']['app']['SetSchedule'](arg1, arg2, arg3, arg4);
+}
+
+export function Stop() {
+  return window['go']['infraui']['app']['Stop']();
+}
+
+export function VerifyDownloads(arg1) {
+  return window['go']['infraui']['app']['VerifyDownloads'](arg1);
+}
@@ -0,0 +1,210 @@
+package infraui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+)
+
+type (
+	jobProgress interface {
+		Snapshot() appctx.ProgressEvent
+		FileStatuses() []appctx.FileStatus
+	}
+	job struct {
+		cancel   context.CancelCauseFunc
+		done     chan struct{}
+		pauser   *appctx.Pauser
+		progress jobProgress
+
+		mu     sync.Mutex
+		record appctx.Job
+	}
+	// 実行中のジョブはバインディングの呼び出しとは別にここで管理する
+	jobRegistry struct {
+		mu      sync.Mutex
+		running map[string]*job
+		// 終わった後も結果を見られるように種類ごとに最後のジョブを残す
+		last map[appctx.JobKind]*job
+	}
+)
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		running: map[string]*job{},
+		last:    map[appctx.JobKind]*job{},
+	}
+}
+
+// 定期ダウンロードは手動のダウンロードと同じ枠で動かし、停止や進捗も同じように扱う
+func slotOf(kind appctx.JobKind) appctx.JobKind {
+	if kind == appctx.JobSync {
+		return appctx.JobDownload
+	}
+	return kind
+}
+
+// 同じ枠のジョブは同時に1つまで、開始した時点で履歴に残す
+func (r *jobRegistry) start(ctx context.Context, kind appctx.JobKind, dir string) (*job, context.Context, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, j := range r.running {
+		if slotOf(j.record.Kind) == slotOf(kind) {
+			return nil, nil, false
+		}
+	}
+
+	j := &job{
+		done:   make(chan struct{}),
+		pauser: appctx.NewPauser(),
+		record: appctx.NewJob(kind, dir),
+	}
+	ctx, j.cancel = context.WithCancelCause(ctx)
+	if p, ok := appctx.Progress(ctx); ok {
+		j.progress = p
+	}
+	r.running[j.record.ID] = j
+	r.last[slotOf(kind)] = j
+	if err := appctx.SaveJob(j.record); err != nil {
+		slog.WarnContext(ctx, "Failed to save job", slog.String("error", err.Error()))
+	}
+
+	return j, appctx.WithPauser(ctx, j.pauser), true
+}
+
+// 結果を履歴に書いてから待っている側に知らせる
+func (r *jobRegistry) finish(j *job, err error) appctx.Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.running[j.record.ID]; !ok {
+		return j.snapshot()
+	}
+
+	j.mu.Lock()
+	if j.progress != nil {
+		e := j.progress.Snapshot()
+		j.record.BytesDone, j.record.BytesTotal = e.BytesDone, e.BytesTotal
+		for _, f := range j.progress.FileStatuses() {
+			if f.State == appctx.FileFailed {
+				j.record.AddError(fmt.Sprintf("%s: %s", f.Filename, f.Reason))
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		j.record.Finish(appctx.JobDone)
+	case errors.Is(err, usecase.ErrCanceled):
+		j.record.Finish(appctx.JobCanceled)
+	default:
+		j.record.AddError(err.Error())
+		j.record.Finish(appctx.JobFailed)
+	}
+	record := j.record
+	j.mu.Unlock()
+
+	if err := appctx.SaveJob(record); err != nil {
+		slog.Warn("Failed to save job", slog.String("job", record.ID), slog.String("error", err.Error()))
+	}
+	j.cancel(nil)
+	close(j.done)
+	delete(r.running, record.ID)
+
+	return record
+}
+
+// 実行中のものは今の進み具合を入れて返す
+func (j *job) snapshot() appctx.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record := j.record
+	if record.Status == appctx.JobRunning && j.progress != nil {
+		e := j.progress.Snapshot()
+		record.BytesDone, record.BytesTotal = e.BytesDone, e.BytesTotal
+	}
+	return record
+}
+
+// 保存済みの履歴に実行中のジョブの状態を重ねる
+func (r *jobRegistry) history() ([]appctx.Job, error) {
+	r.mu.Lock()
+	running := make(map[string]*job, len(r.running))
+	for id, j := range r.running {
+		running[id] = j
+	}
+	r.mu.Unlock()
+
+	jobs, err := appctx.JobHistory()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range jobs {
+		if j, ok := running[record.ID]; ok {
+			jobs[i] = j.snapshot()
+		}
+	}
+	return jobs, nil
+}
+
+// 実行中のものがなければnil
+func (r *jobRegistry) current(kind appctx.JobKind) *job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, j := range r.running {
+		if slotOf(j.record.Kind) == slotOf(kind) {
+			return j
+		}
+	}
+	return nil
+}
+
+func (r *jobRegistry) lastJob(kind appctx.JobKind) *job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last[slotOf(kind)]
+}
+
+// 停止後に後片付けが終わるのを待つためのチャネルを返す
+func (r *jobRegistry) cancel(kind appctx.JobKind) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cancelLocked(func(j *job) bool { return slotOf(j.record.Kind) == slotOf(kind) })
+}
+
+func (r *jobRegistry) cancelAll() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cancelLocked(func(*job) bool { return true })
+}
+
+func (r *jobRegistry) cancelLocked(match func(*job) bool) <-chan struct{} {
+	var dones []chan struct{}
+	for _, j := range r.running {
+		if !match(j) {
+			continue
+		}
+		j.cancel(usecase.ErrCanceled)
+		j.pauser.Resume()
+		dones = append(dones, j.done)
+	}
+
+	all := make(chan struct{})
+	go func() {
+		for _, done := range dones {
+			<-done
+		}
+		close(all)
+	}()
+	return all
+}
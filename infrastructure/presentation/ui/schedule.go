@@ -0,0 +1,147 @@
+package infraui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/util"
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const sessionExpiredMessage = "保存されているログインの有効期限が切れたため、定期バックアップを実行できませんでした。ログインし直してください。"
+
+// 設定が変わるたびに呼び直す、無効なら止めるだけ
+func (a *app) startScheduler() {
+	a.scheduleMu.Lock()
+	defer a.scheduleMu.Unlock()
+
+	if a.scheduleCancel != nil {
+		a.scheduleCancel()
+		a.scheduleCancel = nil
+	}
+
+	conf := appctx.Config(context.TODO()).Schedule
+	if !conf.Enabled {
+		return
+	}
+	schedule, err := scheduleOf(conf)
+	if err != nil {
+		slog.ErrorContext(a.ctx, "Invalid schedule", slog.String("error", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.scheduleCancel = cancel
+	go util.RunSchedule(ctx, schedule, func(context.Context) {
+		// 設定を変えてスケジューラを作り直しても、実行中のダウンロードは止めない
+		ctx := requestContext(a.ctx, conf.UserID)
+		// デーモンが動いている間はそちらに任せる
+		unlock, err := appctx.LockSchedule()
+		if err != nil {
+			slog.WarnContext(ctx, "Skip scheduled download", slog.String("reason", err.Error()))
+			return
+		}
+		defer unlock()
+
+		slog.InfoContext(ctx, "Scheduled download", slog.String("dir", conf.Dir))
+		// 手動のダウンロード中は今回の分を飛ばす
+		job, message := a.runDownload(ctx, conf.Dir, conf.Options, downloadScheduled, a.ucase.ScheduledDownload)
+		if message != "" {
+			slog.WarnContext(ctx, "Skip scheduled download", slog.String("reason", message))
+			return
+		}
+		// 終わるまで次の時刻を数え始めず、ロックも持ったままにする
+		<-job.done
+	})
+}
+
+func scheduleOf(conf appctx.ScheduleConfig) (util.Schedule, error) {
+	if conf.UserID == "" {
+		return nil, errors.New("schedule has no user")
+	}
+	// 作業ディレクトリで変わってしまうので相対パスは使わない
+	if !filepath.IsAbs(conf.Dir) {
+		return nil, fmt.Errorf("schedule dir must be an absolute path: %q", conf.Dir)
+	}
+	return util.ParseSchedule(conf.Interval, conf.Cron)
+}
+
+// 定期バックアップの設定、intervalとcronが両方空なら止める
+func (a *app) SetSchedule(path, options, interval, cron string) string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	enabled := interval != "" || cron != ""
+	if enabled {
+		if !filepath.IsAbs(path) {
+			return "ダウンロード先を選択してから設定してください。"
+		}
+		if _, err := util.ParseSchedule(interval, cron); err != nil {
+			return "失敗しました。(" + err.Error() + ")"
+		}
+		if _, err := parseDownloadOptions(options); err != nil {
+			return "失敗しました。(" + err.Error() + ")"
+		}
+	}
+
+	userID := appctx.User(ctx).ID
+	if enabled && userID == "" {
+		return "ログインしてから設定してください。"
+	}
+	appctx.CacheConfig(func(cf *appctx.ConfigFile) {
+		// 止める場合も設定ファイルで書いたcronは残しておく
+		if !enabled {
+			cron = cf.Schedule.Cron
+		}
+		cf.Schedule = appctx.ScheduleConfig{
+			Enabled:  enabled,
+			UserID:   userID,
+			Dir:      path,
+			Options:  options,
+			Interval: interval,
+			Cron:     cron,
+		}
+	})
+	a.startScheduler()
+
+	return ""
+}
+
+func (a *app) Schedule() string {
+	ctx := a.before("")
+
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+	defer panicTrace(ctx)
+
+	conf := appctx.Config(context.TODO()).Schedule
+	return util.MustJsonString(map[string]any{
+		"enabled":  conf.Enabled,
+		"dir":      conf.Dir,
+		"interval": conf.Interval,
+		"cron":     conf.Cron,
+	})
+}
+
+// 画面をログインに戻し、ダイアログでも知らせる
+func notifySessionExpired(ctx context.Context) {
+	wailsruntime.EventsEmit(ctx, "app_sessionExpired")
+	go warningDialog(ctx, sessionExpiredMessage)
+}
+
+func warningDialog(ctx context.Context, message string) {
+	if _, err := wailsruntime.MessageDialog(ctx, wailsruntime.MessageDialogOptions{
+		Type:    wailsruntime.WarningDialog,
+		Title:   "iCloud Photos Downloader",
+		Message: message,
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to show dialog", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,79 @@
+package infraui
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+	"github.com/take0244/go-icloud-photo-gui/usecase"
+	"github.com/take0244/go-icloud-photo-gui/util"
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// ダウンロード済みのファイルを確認する、結果はダウンロードと同じくapp_jobEventで知らせる
+func (a *app) VerifyDownloads(path string) string {
+	ctx := a.before("")
+
+	job, ctx, ok := a.jobs.start(appctx.WithProgress(ctx), appctx.JobVerify, path)
+	if !ok {
+		return "確認中です。"
+	}
+	wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{Job: job.snapshot()}))
+
+	go func() {
+		message, err := "失敗しました。(予期しないエラー)", errPanicked
+		defer func() {
+			wailsruntime.EventsEmit(ctx, "app_jobEvent", util.MustJsonString(jobEvent{
+				Job:     a.jobs.finish(job, err),
+				Message: message,
+			}))
+		}()
+		defer panicTrace(ctx)
+
+		message, err = verify(ctx, a.ucase, path)
+	}()
+
+	return ""
+}
+
+// 画面を出さずに確認して結果を履歴に残す
+func RunVerify(ctx context.Context, ucase usecase.UseCase, dir string) (appctx.Job, error) {
+	jobs := newJobRegistry()
+	job, ctx, ok := jobs.start(appctx.WithProgress(ctx), appctx.JobVerify, dir)
+	if !ok {
+		return appctx.Job{}, errors.New("verify is already running")
+	}
+
+	// panicした場合も失敗として残す
+	err := errPanicked
+	defer func() {
+		jobs.finish(job, err)
+	}()
+	_, err = verify(ctx, ucase, dir)
+
+	return jobs.finish(job, err), err
+}
+
+func verify(ctx context.Context, ucase usecase.UseCase, dir string) (string, error) {
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+
+	err := ucase.VerifyDownloads(ctx, dir)
+	if p, ok := appctx.Progress(ctx); ok {
+		p.Close()
+	}
+
+	switch {
+	case errors.Is(err, usecase.ErrCanceled):
+		slog.InfoContext(ctx, "Verify stopped")
+		return "停止しました。", err
+	case errors.Is(err, usecase.ErrVerifyFailed):
+		slog.WarnContext(ctx, err.Error())
+		return "見つからないか壊れているファイルがあります。(" + err.Error() + ") 履歴で確認できます。", err
+	case err != nil:
+		slog.ErrorContext(ctx, err.Error())
+		return "失敗しました。(" + err.Error() + ")", err
+	}
+	return "", nil
+}
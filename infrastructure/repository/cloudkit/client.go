@@ -0,0 +1,216 @@
+package infracloudkit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+const (
+	EnvironmentProduction = "production"
+	DatabasePrivate       = "private"
+)
+
+type (
+	Client struct {
+		httpClient  *http.Client
+		baseUrl     string
+		container   string
+		environment string
+		database    string
+	}
+	ZoneID struct {
+		ZoneName        string `json:"zoneName"`
+		OwnerRecordName string `json:"ownerRecordName,omitempty"`
+		ZoneType        string `json:"zoneType,omitempty"`
+	}
+	Zone struct {
+		ZoneID    ZoneID `json:"zoneID"`
+		SyncToken string `json:"syncToken"`
+		Deleted   bool   `json:"deleted"`
+	}
+	FieldValue struct {
+		Type  string `json:"type"`
+		Value any    `json:"value"`
+	}
+	Filter struct {
+		FieldName  string     `json:"fieldName"`
+		FieldValue FieldValue `json:"fieldValue"`
+		Comparator string     `json:"comparator"`
+	}
+	Query struct {
+		RecordType string   `json:"recordType"`
+		FilterBy   []Filter `json:"filterBy,omitempty"`
+	}
+	QueryRequest struct {
+		Query              Query    `json:"query"`
+		ZoneID             ZoneID   `json:"zoneID"`
+		ZoneWide           bool     `json:"zoneWide,omitempty"`
+		ResultsLimit       int      `json:"resultsLimit,omitempty"`
+		DesiredKeys        []string `json:"desiredKeys,omitempty"`
+		ContinuationMarker string   `json:"continuationMarker,omitempty"`
+	}
+	QueryResponse struct {
+		Records            []Record `json:"records"`
+		ContinuationMarker string   `json:"continuationMarker"`
+		SyncToken          string   `json:"syncToken"`
+	}
+	lookupRequest struct {
+		Records     []lookupRecord `json:"records"`
+		ZoneID      ZoneID         `json:"zoneID"`
+		DesiredKeys []string       `json:"desiredKeys,omitempty"`
+	}
+	lookupRecord struct {
+		RecordName string `json:"recordName"`
+	}
+	lookupResponse struct {
+		Records []Record `json:"records"`
+	}
+	zonesResponse struct {
+		Zones []Zone `json:"zones"`
+	}
+	zoneChangesRequest struct {
+		Zones []zoneChangesZone `json:"zones"`
+	}
+	zoneChangesZone struct {
+		ZoneID       ZoneID   `json:"zoneID"`
+		SyncToken    string   `json:"syncToken,omitempty"`
+		ResultsLimit int      `json:"resultsLimit,omitempty"`
+		DesiredKeys  []string `json:"desiredKeys,omitempty"`
+	}
+	ZoneChanges struct {
+		ZoneID     ZoneID   `json:"zoneID"`
+		SyncToken  string   `json:"syncToken"`
+		MoreComing bool     `json:"moreComing"`
+		Records    []Record `json:"records"`
+	}
+	zoneChangesResponse struct {
+		Zones []ZoneChanges `json:"zones"`
+	}
+)
+
+func NewClient(httpClient *http.Client, baseUrl, container string) *Client {
+	return &Client{
+		httpClient:  httpClient,
+		baseUrl:     baseUrl,
+		container:   container,
+		environment: EnvironmentProduction,
+		database:    DatabasePrivate,
+	}
+}
+
+func (c *Client) Query(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	return post[QueryResponse](ctx, c, "records/query", req)
+}
+
+func (c *Client) QueryAll(ctx context.Context, req QueryRequest, fn func([]Record) error) error {
+	for {
+		resp, err := c.Query(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(resp.Records); err != nil {
+			return err
+		}
+
+		if resp.ContinuationMarker == "" {
+			return nil
+		}
+		req.ContinuationMarker = resp.ContinuationMarker
+	}
+}
+
+func (c *Client) Lookup(ctx context.Context, zoneID ZoneID, recordNames []string, desiredKeys []string) ([]Record, error) {
+	req := lookupRequest{ZoneID: zoneID, DesiredKeys: desiredKeys}
+	for _, name := range recordNames {
+		req.Records = append(req.Records, lookupRecord{RecordName: name})
+	}
+
+	resp, err := post[lookupResponse](ctx, c, "records/lookup", req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Records, nil
+}
+
+func (c *Client) ListZones(ctx context.Context) ([]Zone, error) {
+	resp, err := do[zonesResponse](ctx, c, http.MethodGet, "zones/list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Zones, nil
+}
+
+func (c *Client) ZoneChanges(ctx context.Context, zoneID ZoneID, syncToken string, resultsLimit int, desiredKeys []string) (*ZoneChanges, error) {
+	resp, err := post[zoneChangesResponse](ctx, c, "changes/zone", zoneChangesRequest{
+		Zones: []zoneChangesZone{{
+			ZoneID:       zoneID,
+			SyncToken:    syncToken,
+			ResultsLimit: resultsLimit,
+			DesiredKeys:  desiredKeys,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Zones) == 0 {
+		return nil, ErrZoneNotFound
+	}
+
+	return &resp.Zones[0], nil
+}
+
+// records/zip/prepareのようなコンテナ固有のエンドポイント用
+func (c *Client) Post(ctx context.Context, path string, body any) (map[string]any, error) {
+	resp, err := post[map[string]any](ctx, c, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return *resp, nil
+}
+
+func (c *Client) url(path string) string {
+	return util.MustParseUrl(
+		c.baseUrl+"/database/1/"+c.container+"/"+c.environment+"/"+c.database+"/"+path,
+		map[string]string{
+			"remapEnums":          "True",
+			"getCurrentSyncToken": "True",
+		},
+	)
+}
+
+func post[T any](ctx context.Context, c *Client, path string, body any) (*T, error) {
+	return do[T](ctx, c, http.MethodPost, path, body)
+}
+
+func do[T any](ctx context.Context, c *Client, method, path string, body any) (*T, error) {
+	headers := map[string]string{
+		"Content-Type":    "text/plain;charset=UTF-8",
+		"Accept-Encoding": "gzip",
+		"Accept":          "*/*",
+		"Connection":      "keep-alive",
+		"Origin":          "https://www.icloud.com",
+		"Referer":         "https://www.icloud.com/",
+		"User-Agent":      util.UserAgent,
+	}
+
+	var req *http.Request
+	if body != nil {
+		req = util.MustRequest(ctx, method, c.url(path), bytes.NewBuffer(util.MustMarshal(body)), headers)
+	} else {
+		req = util.MustRequest(ctx, method, c.url(path), nil, headers)
+	}
+
+	resp, err := util.HttpDoGzipJSON[T](c.httpClient, req)
+	if err != nil {
+		return nil, cnvError(err)
+	}
+
+	return resp, nil
+}
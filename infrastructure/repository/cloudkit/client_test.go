@@ -0,0 +1,171 @@
+package infracloudkit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewClient(server.Client(), server.URL, "com.apple.photos.cloud")
+}
+
+func TestClientErrorMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantErr    error
+		wantReason string
+	}{
+		{
+			name:       "server error code in body",
+			status:     http.StatusBadRequest,
+			body:       `{"serverErrorCode":"ZONE_NOT_FOUND","reason":"zone missing"}`,
+			wantErr:    ErrZoneNotFound,
+			wantReason: "zone missing",
+		},
+		{
+			name:    "body code wins over status",
+			status:  http.StatusServiceUnavailable,
+			body:    `{"serverErrorCode":"THROTTLED"}`,
+			wantErr: ErrThrottled,
+		},
+		{name: "401 without body", status: http.StatusUnauthorized, wantErr: ErrAuthenticationRequired},
+		{name: "421 without body", status: http.StatusMisdirectedRequest, wantErr: ErrAuthenticationRequired},
+		{name: "403", status: http.StatusForbidden, body: "forbidden", wantErr: ErrAccessDenied, wantReason: "forbidden"},
+		{name: "404", status: http.StatusNotFound, wantErr: ErrNotFound},
+		{name: "429", status: http.StatusTooManyRequests, wantErr: ErrThrottled},
+		{name: "503", status: http.StatusServiceUnavailable, wantErr: ErrTryAgainLater},
+		{name: "500", status: http.StatusInternalServerError, body: "<html>", wantErr: ErrInternal, wantReason: "<html>"},
+		{name: "other 4xx", status: http.StatusConflict, wantErr: &Error{ServerErrorCode: "HTTP_409"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := client.Query(context.Background(), QueryRequest{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			var ckErr *Error
+			if !errors.As(err, &ckErr) {
+				t.Fatalf("err = %T, want *Error", err)
+			}
+			if ckErr.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", ckErr.StatusCode, tt.status)
+			}
+			if tt.wantReason != "" && ckErr.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", ckErr.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestClientRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ErrThrottled, true},
+		{ErrTryAgainLater, true},
+		{ErrInternal, true},
+		{ErrAuthenticationRequired, false},
+		{ErrNotFound, false},
+		{errors.New("other"), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClientQueryAll(t *testing.T) {
+	pages := map[string]QueryResponse{
+		"": {
+			Records:            []Record{{RecordName: "a"}, {RecordName: "b"}},
+			ContinuationMarker: "m1",
+		},
+		"m1": {
+			Records:            []Record{{RecordName: "c"}},
+			ContinuationMarker: "m2",
+		},
+		"m2": {
+			Records: []Record{{RecordName: "d"}},
+		},
+	}
+
+	var markers []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/records/query") {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want gzip", got)
+		}
+
+		var req QueryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		markers = append(markers, req.ContinuationMarker)
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		json.NewEncoder(gz).Encode(pages[req.ContinuationMarker])
+	})
+
+	var names []string
+	err := client.QueryAll(context.Background(), QueryRequest{ResultsLimit: 2}, func(records []Record) error {
+		for _, rec := range records {
+			names = append(names, rec.RecordName)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("QueryAll: %v", err)
+	}
+
+	if got, want := strings.Join(names, ","), "a,b,c,d"; got != want {
+		t.Errorf("records = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(markers, ","), ",m1,m2"; got != want {
+		t.Errorf("markers = %s, want %s", got, want)
+	}
+}
+
+func TestClientQueryAllStopsOnCallbackError(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(QueryResponse{
+			Records:            []Record{{RecordName: "a"}},
+			ContinuationMarker: "next",
+		})
+	})
+
+	stop := errors.New("stop")
+	err := client.QueryAll(context.Background(), QueryRequest{}, func([]Record) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Fatalf("err = %v, want %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
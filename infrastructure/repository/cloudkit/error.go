@@ -0,0 +1,95 @@
+package infracloudkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/take0244/go-icloud-photo-gui/util"
+)
+
+var (
+	ErrAuthenticationFailed   = &Error{ServerErrorCode: "AUTHENTICATION_FAILED"}
+	ErrAuthenticationRequired = &Error{ServerErrorCode: "AUTHENTICATION_REQUIRED"}
+	ErrAccessDenied           = &Error{ServerErrorCode: "ACCESS_DENIED"}
+	ErrBadRequest             = &Error{ServerErrorCode: "BAD_REQUEST"}
+	ErrNotFound               = &Error{ServerErrorCode: "NOT_FOUND"}
+	ErrZoneNotFound           = &Error{ServerErrorCode: "ZONE_NOT_FOUND"}
+	ErrQuotaExceeded          = &Error{ServerErrorCode: "QUOTA_EXCEEDED"}
+	ErrThrottled              = &Error{ServerErrorCode: "THROTTLED"}
+	ErrTryAgainLater          = &Error{ServerErrorCode: "TRY_AGAIN_LATER"}
+	ErrInternal               = &Error{ServerErrorCode: "INTERNAL_ERROR"}
+)
+
+type Error struct {
+	StatusCode      int    `json:"-"`
+	ServerErrorCode string `json:"serverErrorCode"`
+	Reason          string `json:"reason"`
+	UUID            string `json:"uuid"`
+	RecordName      string `json:"recordName"`
+}
+
+func (e *Error) Error() string {
+	msg := "cloudkit: " + e.ServerErrorCode
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%s (HTTP %d)", msg, e.StatusCode)
+	}
+	if e.RecordName != "" {
+		msg += " record=" + e.RecordName
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.ServerErrorCode == e.ServerErrorCode
+}
+
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrThrottled) ||
+		errors.Is(err, ErrTryAgainLater) ||
+		errors.Is(err, ErrInternal)
+}
+
+func cnvError(err error) error {
+	var httpErr *util.HttpError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+
+	ckErr, parseErr := util.Unmarshal[Error](httpErr.Body)
+	if parseErr != nil || ckErr.ServerErrorCode == "" {
+		ckErr = &Error{ServerErrorCode: codeFromStatus(httpErr.StatusCode), Reason: string(httpErr.Body)}
+	}
+	ckErr.StatusCode = httpErr.StatusCode
+
+	return ckErr
+}
+
+func codeFromStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized, http.StatusMisdirectedRequest:
+		return ErrAuthenticationRequired.ServerErrorCode
+	case http.StatusForbidden:
+		return ErrAccessDenied.ServerErrorCode
+	case http.StatusNotFound:
+		return ErrNotFound.ServerErrorCode
+	case http.StatusTooManyRequests:
+		return ErrThrottled.ServerErrorCode
+	case http.StatusServiceUnavailable:
+		return ErrTryAgainLater.ServerErrorCode
+	case http.StatusBadRequest:
+		return ErrBadRequest.ServerErrorCode
+	}
+
+	if status >= 500 {
+		return ErrInternal.ServerErrorCode
+	}
+	return fmt.Sprintf("HTTP_%d", status)
+}
@@ -0,0 +1,147 @@
+package infracloudkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	TypeString         = "STRING"
+	TypeStringList     = "STRING_LIST"
+	TypeInt64          = "INT64"
+	TypeTimestamp      = "TIMESTAMP"
+	TypeEncryptedBytes = "ENCRYPTED_BYTES"
+	TypeAssetId        = "ASSETID"
+	TypeReference      = "REFERENCE"
+)
+
+var ErrFieldNotFound = errors.New("field not found")
+
+type (
+	Field struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	Fields map[string]Field
+	Record struct {
+		RecordType      string `json:"recordType"`
+		RecordName      string `json:"recordName"`
+		RecordChangeTag string `json:"recordChangeTag"`
+		Fields          Fields `json:"fields"`
+		Deleted         bool   `json:"deleted"`
+		ServerErrorCode string `json:"serverErrorCode"`
+		Reason          string `json:"reason"`
+	}
+	Asset struct {
+		FileChecksum      string  `json:"fileChecksum"`
+		Size              float64 `json:"size"`
+		DownloadURL       string  `json:"downloadURL"`
+		WrappingKey       string  `json:"wrappingKey"`
+		ReferenceChecksum string  `json:"referenceChecksum"`
+	}
+	Reference struct {
+		RecordName string `json:"recordName"`
+		Action     string `json:"action"`
+		ZoneID     ZoneID `json:"zoneID"`
+	}
+	FieldError struct {
+		Field string
+		Type  string
+		Err   error
+	}
+)
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s (%s): %s", e.Field, e.Type, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func (r Record) Err() error {
+	if r.ServerErrorCode == "" {
+		return nil
+	}
+
+	return &Error{
+		ServerErrorCode: r.ServerErrorCode,
+		Reason:          r.Reason,
+		RecordName:      r.RecordName,
+	}
+}
+
+func (f Fields) decode(name string, types []string, v any) error {
+	field, ok := f[name]
+	if !ok || len(field.Value) == 0 {
+		return &FieldError{Field: name, Err: ErrFieldNotFound}
+	}
+
+	for _, t := range types {
+		if field.Type != t {
+			continue
+		}
+		if err := json.Unmarshal(field.Value, v); err != nil {
+			return &FieldError{Field: name, Type: field.Type, Err: err}
+		}
+		return nil
+	}
+
+	return &FieldError{Field: name, Type: field.Type, Err: fmt.Errorf("unexpected type, want %v", types)}
+}
+
+func (f Fields) String(name string) (string, error) {
+	var v string
+	err := f.decode(name, []string{TypeString}, &v)
+	return v, err
+}
+
+func (f Fields) Int64(name string) (int64, error) {
+	var v int64
+	err := f.decode(name, []string{TypeInt64}, &v)
+	return v, err
+}
+
+func (f Fields) Timestamp(name string) (time.Time, error) {
+	var v int64
+	if err := f.decode(name, []string{TypeTimestamp}, &v); err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(v), nil
+}
+
+// filenameEncはSTRINGで来ることもある
+func (f Fields) EncryptedBytes(name string) ([]byte, error) {
+	var v string
+	if err := f.decode(name, []string{TypeEncryptedBytes, TypeString}, &v); err != nil {
+		return nil, err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, &FieldError{Field: name, Type: f[name].Type, Err: err}
+	}
+	return b, nil
+}
+
+func (f Fields) Asset(name string) (Asset, error) {
+	var v Asset
+	err := f.decode(name, []string{TypeAssetId}, &v)
+	return v, err
+}
+
+func (f Fields) Reference(name string) (Reference, error) {
+	var v Reference
+	err := f.decode(name, []string{TypeReference}, &v)
+	return v, err
+}
+
+func Optional[T any](v T, err error) (T, error) {
+	if errors.Is(err, ErrFieldNotFound) {
+		return v, nil
+	}
+	return v, err
+}
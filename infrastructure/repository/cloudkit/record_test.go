@@ -0,0 +1,114 @@
+package infracloudkit
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testFields(t *testing.T, raw string) Fields {
+	t.Helper()
+	var fields Fields
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		t.Fatalf("unmarshal fields: %v", err)
+	}
+	return fields
+}
+
+func TestFieldsDecoders(t *testing.T) {
+	fields := testFields(t, `{
+		"str": {"type": "STRING", "value": "hello"},
+		"num": {"type": "INT64", "value": 42},
+		"ts": {"type": "TIMESTAMP", "value": 1700000000000},
+		"enc": {"type": "ENCRYPTED_BYTES", "value": "aGVsbG8="},
+		"encStr": {"type": "STRING", "value": "aGVsbG8="},
+		"badB64": {"type": "ENCRYPTED_BYTES", "value": "%%%"},
+		"asset": {"type": "ASSETID", "value": {"fileChecksum": "ck", "size": 10, "downloadURL": "https://example.com/a"}},
+		"ref": {"type": "REFERENCE", "value": {"recordName": "master-1", "action": "NONE"}},
+		"strAsNum": {"type": "STRING", "value": 1},
+		"empty": {"type": "STRING"}
+	}`)
+
+	tests := []struct {
+		name    string
+		decode  func() (any, error)
+		want    any
+		wantErr error
+		// エラーの型だけ確認する
+		wantFieldErr bool
+	}{
+		{name: "string", decode: func() (any, error) { return fields.String("str") }, want: "hello"},
+		{name: "int64", decode: func() (any, error) { return fields.Int64("num") }, want: int64(42)},
+		{name: "timestamp", decode: func() (any, error) { return fields.Timestamp("ts") }, want: time.UnixMilli(1700000000000)},
+		{name: "encrypted bytes", decode: func() (any, error) {
+			b, err := fields.EncryptedBytes("enc")
+			return string(b), err
+		}, want: "hello"},
+		{name: "encrypted bytes as string", decode: func() (any, error) {
+			b, err := fields.EncryptedBytes("encStr")
+			return string(b), err
+		}, want: "hello"},
+		{name: "asset", decode: func() (any, error) { return fields.Asset("asset") }, want: Asset{FileChecksum: "ck", Size: 10, DownloadURL: "https://example.com/a"}},
+		{name: "reference", decode: func() (any, error) { return fields.Reference("ref") }, want: Reference{RecordName: "master-1", Action: "NONE"}},
+
+		{name: "missing field", decode: func() (any, error) { return fields.String("nope") }, wantErr: ErrFieldNotFound},
+		{name: "missing value", decode: func() (any, error) { return fields.String("empty") }, wantErr: ErrFieldNotFound},
+		{name: "wrong type", decode: func() (any, error) { return fields.Int64("str") }, wantFieldErr: true},
+		{name: "wrong type for asset", decode: func() (any, error) { return fields.Asset("ref") }, wantFieldErr: true},
+		{name: "value does not match type", decode: func() (any, error) { return fields.String("strAsNum") }, wantFieldErr: true},
+		{name: "bad base64", decode: func() (any, error) { return fields.EncryptedBytes("badB64") }, wantFieldErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.decode()
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantFieldErr:
+				var fieldErr *FieldError
+				if !errors.As(err, &fieldErr) || errors.Is(err, ErrFieldNotFound) {
+					t.Fatalf("err = %v, want a type error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if t1, ok := tt.want.(time.Time); ok {
+					if !t1.Equal(got.(time.Time)) {
+						t.Errorf("got %v, want %v", got, tt.want)
+					}
+					return
+				}
+				if got != tt.want {
+					t.Errorf("got %#v, want %#v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOptional(t *testing.T) {
+	fields := testFields(t, `{"str": {"type": "STRING", "value": "x"}}`)
+
+	if v, err := Optional(fields.String("nope")); err != nil || v != "" {
+		t.Errorf("missing: got %q, %v", v, err)
+	}
+	if _, err := Optional(fields.Int64("str")); err == nil {
+		t.Error("wrong type: want error")
+	}
+}
+
+func TestRecordErr(t *testing.T) {
+	if err := (Record{RecordName: "a"}).Err(); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+
+	err := Record{RecordName: "a", ServerErrorCode: "NOT_FOUND", Reason: "gone"}.Err()
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrNotFound)
+	}
+}
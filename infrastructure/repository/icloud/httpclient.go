@@ -4,7 +4,7 @@ import (
 	"net/http"
 	"sync"
 
-	"github.com/take0244/go-icloud-photo-gui/aop"
+	"github.com/take0244/go-icloud-photo-gui/appctx"
 	"github.com/take0244/go-icloud-photo-gui/util"
 )
 
@@ -52,20 +52,9 @@ func (sm *SessionManager) getSessionData(userID string) *SessionData {
 	}
 
 	if data.client == nil {
-		if aop.IsDebug() {
-			data.client = &http.Client{
-				Transport: util.NewLoggingTransport(
-					util.NewCacheTransport(
-						http.DefaultTransport, "./.cache",
-					),
-				),
-				Jar: util.NewPersistentCookieJar(),
-			}
-		} else {
-			data.client = &http.Client{
-				Transport: util.NewLoggingTransport(http.DefaultTransport),
-				Jar:       util.NewPersistentCookieJar(),
-			}
+		data.client = &http.Client{
+			Transport: appctx.HttpTransport(util.EndpointQuery),
+			Jar:       util.NewPersistentCookieJar(),
 		}
 	}
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/take0244/go-icloud-photo-gui/appctx"
+	infracloudkit "github.com/take0244/go-icloud-photo-gui/infrastructure/repository/cloudkit"
 	"github.com/take0244/go-icloud-photo-gui/usecase"
 	"github.com/take0244/go-icloud-photo-gui/util"
 )
@@ -28,6 +29,7 @@ func NewICloud() usecase.ICloudService {
 }
 
 func (i *ifICloud) Login(ctx context.Context, appleId, password string) (bool, error) {
+	ctx = util.WithEndpoint(ctx, util.EndpointAuth)
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 
@@ -65,6 +67,7 @@ func (i *ifICloud) Login(ctx context.Context, appleId, password string) (bool, e
 }
 
 func (i *ifICloud) Code2fa(ctx context.Context, code string) error {
+	ctx = util.WithEndpoint(ctx, util.EndpointAuth)
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 
@@ -92,6 +95,28 @@ func (i *ifICloud) Code2fa(ctx context.Context, code string) error {
 	return nil
 }
 
+func (i *ifICloud) RestoreSession(ctx context.Context) error {
+	ctx = util.WithEndpoint(ctx, util.EndpointAuth)
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+
+	_, _, appleInfo, user := MetaData(ctx)
+	session := sessionManager.getSessionData(user.ID)
+	if appleInfo.WebServiceSckdatabasewsUrl == "" || !appctx.ApplyCookieJar(ctx, session.client.Jar, user.ID) {
+		return usecase.ErrSessionExpired
+	}
+
+	// 通信に失敗しただけの場合はcookieを残して次回に任せる
+	if err := i.validateCookie(ctx); errors.Is(err, errInvalidCookie) {
+		appctx.ClearCookiesCache(user.ID)
+		return usecase.ErrSessionExpired
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (i *ifICloud) loadMeta(ctx context.Context) (okCookie bool) {
 	_, _, _, user := MetaData(ctx)
 	session := sessionManager.getSessionData(user.ID)
@@ -126,3 +151,8 @@ func MetaData(ctx context.Context) (*http.Client, appctx.ConfigFile, appctx.Appl
 
 	return httpClient, config, appInfo, user
 }
+
+func cloudKit(ctx context.Context) *infracloudkit.Client {
+	httpClient, _, appleInfo, _ := MetaData(ctx)
+	return infracloudkit.NewClient(httpClient, appleInfo.WebServiceSckdatabasewsUrl, "com.apple.photos.cloud")
+}
@@ -206,6 +206,8 @@ func (a *authService) trustSession(ctx context.Context, clientId string, signinR
 	}, nil
 }
 
+var errInvalidCookie = errors.New("invalid cookie")
+
 func (a *authService) validateCookie(ctx context.Context) error {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
@@ -226,11 +228,19 @@ func (a *authService) validateCookie(ctx context.Context) error {
 		},
 	)
 
-	if resp, err := httpClient.Do(req); err != nil {
-		return fmt.Errorf("failed to trust request: %w", err)
-	} else if !util.HttpCheck2XX(resp) {
-		return errors.New("invalid cookie")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to validate request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 期限切れと分かる場合だけerrInvalidCookie、5xxや429は一時的な失敗として返す
+	switch {
+	case util.HttpCheck2XX(resp):
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusMisdirectedRequest:
+		return errInvalidCookie
+	default:
+		return fmt.Errorf("failed to validate cookie: %s", resp.Status)
 	}
-
-	return nil
 }
@@ -1,18 +1,18 @@
 package infraicloud
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/take0244/go-icloud-photo-gui/aop"
 	"github.com/take0244/go-icloud-photo-gui/appctx"
+	infracloudkit "github.com/take0244/go-icloud-photo-gui/infrastructure/repository/cloudkit"
 	"github.com/take0244/go-icloud-photo-gui/usecase"
 	"github.com/take0244/go-icloud-photo-gui/util"
 )
@@ -20,191 +20,423 @@ import (
 type (
 	photoService struct{}
 	Photo        struct {
-		Fields       map[string]any
-		MasterFields map[string]any
+		Fields       infracloudkit.Fields
+		MasterFields infracloudkit.Fields
 		RecordName   string
+		rank         int64
 	}
-	response struct {
-		Records []responseRecord `json:"records"`
+	// ranksはこのページで進んだstartRankの数、アセット1件が1つにあたる
+	photoPageResult struct {
+		offset         int64
+		ranks          int64
+		photos         []Photo
+		malformed      []usecase.MalformedRecord
+		malformedRanks []int64
+		err            error
 	}
-	responseRecord struct {
-		RecordType string         `json:"recordType"`
-		Fields     map[string]any `json:"fields"`
-		RecordName string         `json:"recordName"`
+)
+
+const (
+	pageSize         = 100
+	pageRetryCount   = 4
+	pageRetryBackoff = time.Second
+)
+
+var (
+	primaryZone = infracloudkit.ZoneID{ZoneName: "PrimarySync"}
+	desiredKeys = []string{
+		"resJPEGFullWidth", "resJPEGFullHeight", "resJPEGFullFileType", "resJPEGFullFingerprint", "resJPEGFullRes",
+		"resJPEGLargeWidth", "resJPEGLargeHeight", "resJPEGLargeFileType", "resJPEGLargeFingerprint", "resJPEGLargeRes",
+		"resJPEGMedWidth", "resJPEGMedHeight", "resJPEGMedFileType", "resJPEGMedFingerprint", "resJPEGMedRes",
+		"resJPEGThumbWidth", "resJPEGThumbHeight", "resJPEGThumbFileType", "resJPEGThumbFingerprint", "resJPEGThumbRes",
+		"resVidFullWidth", "resVidFullHeight", "resVidFullFileType", "resVidFullFingerprint", "resVidFullRes",
+		"resVidMedWidth", "resVidMedHeight", "resVidMedFileType", "resVidMedFingerprint", "resVidMedRes",
+		"resVidSmallWidth", "resVidSmallHeight", "resVidSmallFileType", "resVidSmallFingerprint", "resVidSmallRes",
+		"resSidecarWidth", "resSidecarHeight", "resSidecarFileType", "resSidecarFingerprint", "resSidecarRes",
+		"itemType", "dataClassType", "filenameEnc", "originalOrientation", "resOriginalWidth", "resOriginalHeight",
+		"resOriginalFileType", "resOriginalFingerprint", "resOriginalRes", "resOriginalAltWidth", "resOriginalAltHeight",
+		"resOriginalAltFileType", "resOriginalAltFingerprint", "resOriginalAltRes", "resOriginalVidComplWidth",
+		"resOriginalVidComplHeight", "resOriginalVidComplFileType", "resOriginalVidComplFingerprint", "resOriginalVidComplRes",
+		"isDeleted", "isExpunged", "dateExpunged", "remappedRef", "recordName", "recordType", "recordChangeTag",
+		"masterRef", "adjustmentRenderType", "assetDate", "addedDate", "isFavorite", "isHidden", "orientation", "duration",
+		"assetSubtype", "assetSubtypeV2", "assetHDRType", "burstFlags", "burstFlagsExt", "burstId", "captionEnc",
+		"locationEnc", "locationV2Enc", "locationLatitude", "locationLongitude", "adjustmentType", "timeZoneOffset",
+		"vidComplDurValue", "vidComplDurScale", "vidComplDispValue", "vidComplDispScale", "vidComplVisibilityState",
+		"customRenderedValue", "containerId", "itemId", "position", "isKeyAsset",
 	}
 )
 
-func (p *photoService) getPhotos(ctx context.Context, offset int64) ([]Photo, error) {
+func (p *photoService) getPhotos(ctx context.Context, offset int64) (photoPageResult, error) {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 
-	httpClient, _, appleInfo, _ := MetaData(ctx)
-
 	if aop.IsDebug() {
 		ctx = util.WithCache(ctx, true)
 	}
 
-	url := util.MustParseUrl(
-		appleInfo.WebServiceSckdatabasewsUrl+"/database/1/com.apple.photos.cloud/production/private/records/query",
-		map[string]string{
-			"remapEnums":          "True",
-			"getCurrentSyncToken": "True",
-		},
-	)
-	headers := map[string]string{
-		"Content-Type":    "text/plain",
-		"Accept-Encoding": "gzip, deflate",
-		"Accept":          "*/*",
-		"Connection":      "keep-alive",
-		"Origin":          "https://www.icloud.com",
-		"Referer":         "https://www.icloud.com/",
-		"User-Agent":      util.UserAgent,
-	}
-
-	resp, err := util.HttpDoGzipJSON[response](
-		httpClient,
-		util.MustRequest(
-			ctx,
-			http.MethodPost,
-			url,
-			bytes.NewBuffer(util.MustMarshal(map[string]any{
-				"query": map[string]any{
-					"filterBy": []map[string]any{
-						{
-							"fieldName":  "startRank",
-							"fieldValue": map[string]any{"type": "INT64", "value": offset},
-							"comparator": "EQUALS",
-						},
-						{
-							"fieldName":  "direction",
-							"fieldValue": map[string]any{"type": "STRING", "value": "ASCENDING"},
-							"comparator": "EQUALS",
-						},
-					},
-					"recordType": "CPLAssetAndMasterByAssetDateWithoutHiddenOrDeleted",
+	resp, err := cloudKit(ctx).Query(ctx, infracloudkit.QueryRequest{
+		Query: infracloudkit.Query{
+			RecordType: "CPLAssetAndMasterByAssetDateWithoutHiddenOrDeleted",
+			FilterBy: []infracloudkit.Filter{
+				{
+					FieldName:  "startRank",
+					FieldValue: infracloudkit.FieldValue{Type: infracloudkit.TypeInt64, Value: offset},
+					Comparator: "EQUALS",
 				},
-				"resultsLimit": 200,
-				"desiredKeys": []string{
-					"resJPEGFullWidth", "resJPEGFullHeight", "resJPEGFullFileType", "resJPEGFullFingerprint", "resJPEGFullRes",
-					"resJPEGLargeWidth", "resJPEGLargeHeight", "resJPEGLargeFileType", "resJPEGLargeFingerprint", "resJPEGLargeRes",
-					"resJPEGMedWidth", "resJPEGMedHeight", "resJPEGMedFileType", "resJPEGMedFingerprint", "resJPEGMedRes",
-					"resJPEGThumbWidth", "resJPEGThumbHeight", "resJPEGThumbFileType", "resJPEGThumbFingerprint", "resJPEGThumbRes",
-					"resVidFullWidth", "resVidFullHeight", "resVidFullFileType", "resVidFullFingerprint", "resVidFullRes",
-					"resVidMedWidth", "resVidMedHeight", "resVidMedFileType", "resVidMedFingerprint", "resVidMedRes",
-					"resVidSmallWidth", "resVidSmallHeight", "resVidSmallFileType", "resVidSmallFingerprint", "resVidSmallRes",
-					"resSidecarWidth", "resSidecarHeight", "resSidecarFileType", "resSidecarFingerprint", "resSidecarRes",
-					"itemType", "dataClassType", "filenameEnc", "originalOrientation", "resOriginalWidth", "resOriginalHeight",
-					"resOriginalFileType", "resOriginalFingerprint", "resOriginalRes", "resOriginalAltWidth", "resOriginalAltHeight",
-					"resOriginalAltFileType", "resOriginalAltFingerprint", "resOriginalAltRes", "resOriginalVidComplWidth",
-					"resOriginalVidComplHeight", "resOriginalVidComplFileType", "resOriginalVidComplFingerprint", "resOriginalVidComplRes",
-					"isDeleted", "isExpunged", "dateExpunged", "remappedRef", "recordName", "recordType", "recordChangeTag",
-					"masterRef", "adjustmentRenderType", "assetDate", "addedDate", "isFavorite", "isHidden", "orientation", "duration",
-					"assetSubtype", "assetSubtypeV2", "assetHDRType", "burstFlags", "burstFlagsExt", "burstId", "captionEnc",
-					"locationEnc", "locationV2Enc", "locationLatitude", "locationLongitude", "adjustmentType", "timeZoneOffset",
-					"vidComplDurValue", "vidComplDurScale", "vidComplDispValue", "vidComplDispScale", "vidComplVisibilityState",
-					"customRenderedValue", "containerId", "itemId", "position", "isKeyAsset",
+				{
+					FieldName:  "direction",
+					FieldValue: infracloudkit.FieldValue{Type: infracloudkit.TypeString, Value: "ASCENDING"},
+					Comparator: "EQUALS",
 				},
-				"zoneID": map[string]string{"zoneName": "PrimarySync"},
-			})),
-			headers,
-		),
-	)
+			},
+		},
+		ZoneID:       primaryZone,
+		ResultsLimit: pageSize * 2,
+		DesiredKeys:  desiredKeys,
+	})
 	if err != nil {
-		return nil, err
+		return photoPageResult{}, err
 	}
 
-	assetRecords := map[string]responseRecord{}
-	masterRecords := []responseRecord{}
+	// レスポンスはrank順に並んでいる、件数の上限で途中までしか返らないこともある
+	res := photoPageResult{offset: offset}
+	assetRecords := map[string]infracloudkit.Record{}
+	assetRanks := map[string]int64{}
+	masterRecords := []infracloudkit.Record{}
 	for _, rec := range resp.Records {
+		// マスター側のエラーはページの先頭のrankとして扱う
+		rank := offset
+		if rec.RecordType == "CPLAsset" {
+			rank = offset + res.ranks
+			res.ranks++
+		}
+		if err := rec.Err(); err != nil {
+			res.addMalformed(malformedRecord(rec, err), rank)
+			continue
+		}
+
 		if rec.RecordType == "CPLAsset" {
-			masterID := rec.Fields["masterRef"].(map[string]any)["value"].(map[string]any)["recordName"].(string)
-			assetRecords[masterID] = rec
+			masterRef, err := rec.Fields.Reference("masterRef")
+			if err != nil {
+				res.addMalformed(malformedRecord(rec, err), rank)
+				continue
+			}
+			assetRecords[masterRef.RecordName] = rec
+			assetRanks[masterRef.RecordName] = rank
 		} else if rec.RecordType == "CPLMaster" {
 			masterRecords = append(masterRecords, rec)
 		}
 	}
 
-	var photos []Photo
 	for _, masterRecord := range masterRecords {
 		if asset, exists := assetRecords[masterRecord.RecordName]; exists {
-			photos = append(photos, Photo{
+			res.photos = append(res.photos, Photo{
 				RecordName:   asset.RecordName,
 				Fields:       asset.Fields,
 				MasterFields: masterRecord.Fields,
+				rank:         assetRanks[masterRecord.RecordName],
 			})
 		}
 	}
 
-	return photos, nil
+	return res, nil
+}
+
+func (r *photoPageResult) addMalformed(rec usecase.MalformedRecord, rank int64) {
+	r.malformed = append(r.malformed, rec)
+	r.malformedRanks = append(r.malformedRanks, rank)
+}
+
+// end以降のrankは次のページと被るので落とす
+func (r photoPageResult) before(end int64) photoPageResult {
+	trimmed := photoPageResult{offset: r.offset, ranks: min(r.ranks, max(end-r.offset, 0))}
+	for _, photo := range r.photos {
+		if photo.rank < end {
+			trimmed.photos = append(trimmed.photos, photo)
+		}
+	}
+	for i, rec := range r.malformed {
+		if r.malformedRanks[i] < end {
+			trimmed.addMalformed(rec, r.malformedRanks[i])
+		}
+	}
+	return trimmed
+}
+
+func (p *photoService) StreamPhotos(ctx context.Context) <-chan usecase.PhotoPage {
+	ch := make(chan usecase.PhotoPage, 1)
+
+	go func() {
+		appctx.AppTrace(ctx)
+		defer appctx.DeferAppTrace(ctx)
+		defer close(ch)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		progress, okProgress := appctx.Progress(ctx)
+		workers := max(appctx.Config(ctx).MaxParallel, 1)
+
+		var total int64
+		err := util.Retry(ctx, pageRetryCount, pageRetryBackoff, isRetryable, func() (err error) {
+			total, err = p.countPhotos(ctx)
+			return err
+		})
+		if err != nil {
+			sendPage(ctx, ch, usecase.PhotoPage{Err: fmt.Errorf("failed to count photos: %w", err)})
+			return
+		}
+
+		// 件数が分かっているのでstartRankごとに並列で取得して順番に流す
+		windows := int((total + pageSize - 1) / pageSize)
+		results := make([]chan photoPageResult, windows)
+		for i := range results {
+			results[i] = make(chan photoPageResult, 1)
+		}
+		slots := make(chan struct{}, workers)
+		go func() {
+			for i := range windows {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				go func() {
+					results[i] <- p.fetchPage(ctx, int64(i)*pageSize)
+				}()
+			}
+		}()
+
+		listed := int64(0)
+		for i := range windows {
+			var res photoPageResult
+			select {
+			case res = <-results[i]:
+			case <-ctx.Done():
+				return
+			}
+			<-slots
+
+			if res.err != nil {
+				sendPage(ctx, ch, usecase.PhotoPage{Err: res.err})
+				return
+			}
+			// 途中までしか返らなかった場合は、次の窓の手前まで実際の位置から取り直す
+			end := int64(i+1) * pageSize
+			res = res.before(end)
+			for next := res.offset + res.ranks; res.ranks > 0 && next < end; {
+				gap := p.fetchPage(ctx, next)
+				if gap.err != nil {
+					sendPage(ctx, ch, usecase.PhotoPage{Err: gap.err})
+					return
+				}
+				gap = gap.before(end)
+				if gap.ranks == 0 {
+					break
+				}
+				res.photos = append(res.photos, gap.photos...)
+				res.malformed = append(res.malformed, gap.malformed...)
+				res.ranks += gap.ranks
+				next += gap.ranks
+			}
+			listed += res.ranks
+			if okProgress {
+				progress.SetListing(float64(listed), float64(total))
+			}
+			if !sendPage(ctx, ch, cnvPage(ctx, res.photos, res.malformed)) {
+				return
+			}
+		}
+
+		// 一覧中に増えた分は順番に取得
+		for offset := int64(windows) * pageSize; ; {
+			res := p.fetchPage(ctx, offset)
+			if res.err != nil {
+				sendPage(ctx, ch, usecase.PhotoPage{Err: res.err})
+				return
+			}
+
+			if res.ranks == 0 {
+				return
+			}
+			offset += res.ranks
+
+			if !sendPage(ctx, ch, cnvPage(ctx, res.photos, res.malformed)) {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (p *photoService) fetchPage(ctx context.Context, offset int64) photoPageResult {
+	var res photoPageResult
+	err := util.Retry(ctx, pageRetryCount, pageRetryBackoff, isRetryable, func() (err error) {
+		res, err = p.getPhotos(ctx, offset)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to get photos", slog.Int64("offset", offset), slog.String("error", err.Error()))
+		}
+		return err
+	})
+	if err != nil {
+		res.err = fmt.Errorf("failed to get photos (offset %d): %w", offset, err)
+	}
+
+	return res
 }
 
-func (p *photoService) getAllPhotos(ctx context.Context) []Photo {
+// 再試行用、一覧から消えた写真は隔離に回す
+func (p *photoService) LookupPhotos(ctx context.Context, ids []string) usecase.PhotoPage {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
-	progress, ok := appctx.Progress(ctx)
 
 	var (
-		allPhotos []Photo
-		offset    = int64(0)
+		photos    []Photo
+		malformed []usecase.MalformedRecord
 	)
-
-	for {
-		photos, err := p.getPhotos(ctx, offset)
+	for chunk := range slices.Chunk(ids, pageSize) {
+		var (
+			chunkPhotos    []Photo
+			chunkMalformed []usecase.MalformedRecord
+		)
+		err := util.Retry(ctx, pageRetryCount, pageRetryBackoff, isRetryable, func() (err error) {
+			chunkPhotos, chunkMalformed, err = p.lookupPhotos(ctx, chunk)
+			return err
+		})
 		if err != nil {
-			return nil
+			return usecase.PhotoPage{Err: fmt.Errorf("failed to lookup photos: %w", err)}
 		}
+		photos = append(photos, chunkPhotos...)
+		malformed = append(malformed, chunkMalformed...)
+	}
 
-		if len(photos) == 0 {
-			break
-		}
+	return cnvPage(ctx, photos, malformed)
+}
 
-		allPhotos = append(allPhotos, photos...)
-		if ok {
-			progress.Count("photos_count", float64(len(allPhotos)))
+func (p *photoService) lookupPhotos(ctx context.Context, ids []string) ([]Photo, []usecase.MalformedRecord, error) {
+	client := cloudKit(ctx)
+	assets, err := client.Lookup(ctx, primaryZone, ids, desiredKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		malformed   []usecase.MalformedRecord
+		assetByRef  = map[string]infracloudkit.Record{}
+		masterNames []string
+	)
+	for _, rec := range assets {
+		if err := rec.Err(); err != nil {
+			malformed = append(malformed, malformedRecord(rec, err))
+			continue
 		}
+		masterRef, err := rec.Fields.Reference("masterRef")
+		if err != nil {
+			malformed = append(malformed, malformedRecord(rec, err))
+			continue
+		}
+		assetByRef[masterRef.RecordName] = rec
+		masterNames = append(masterNames, masterRef.RecordName)
+	}
+	if len(masterNames) == 0 {
+		return nil, malformed, nil
+	}
 
-		offset += int64(len(photos))
+	masters, err := client.Lookup(ctx, primaryZone, masterNames, desiredKeys)
+	if err != nil {
+		return nil, nil, err
 	}
-	return allPhotos
+
+	var photos []Photo
+	for _, rec := range masters {
+		if err := rec.Err(); err != nil {
+			malformed = append(malformed, malformedRecord(rec, err))
+			continue
+		}
+		if asset, exists := assetByRef[rec.RecordName]; exists {
+			photos = append(photos, Photo{
+				RecordName:   asset.RecordName,
+				Fields:       asset.Fields,
+				MasterFields: rec.Fields,
+			})
+		}
+	}
+
+	return photos, malformed, nil
 }
 
-func (p *photoService) GetAllPhotos(ctx context.Context) []usecase.Photo {
+func (p *photoService) countPhotos(ctx context.Context) (int64, error) {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 
-	var result []usecase.Photo
-	for _, p := range p.getAllPhotos(ctx) {
+	resp, err := cloudKit(ctx).Query(ctx, infracloudkit.QueryRequest{
+		Query: infracloudkit.Query{
+			RecordType: "HyperionIndexCountLookup",
+			FilterBy: []infracloudkit.Filter{
+				{
+					FieldName:  "indexCountID",
+					FieldValue: infracloudkit.FieldValue{Type: infracloudkit.TypeStringList, Value: []string{"CPLAssetByAssetDateWithoutHiddenOrDeleted"}},
+					Comparator: "IN",
+				},
+			},
+		},
+		ZoneID:   primaryZone,
+		ZoneWide: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Records) == 0 {
+		return 0, errors.New("missing count record")
+	}
+
+	return resp.Records[0].Fields.Int64("itemCount")
+}
+
+func sendPage(ctx context.Context, ch chan<- usecase.PhotoPage, page usecase.PhotoPage) bool {
+	select {
+	case ch <- page:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func cnvPage(ctx context.Context, photos []Photo, malformed []usecase.MalformedRecord) usecase.PhotoPage {
+	page := usecase.PhotoPage{Malformed: malformed}
+	for _, p := range photos {
 		v, err := cnvPhoto(p)
 		if err != nil {
-			slog.ErrorContext(ctx, err.Error()+p.RecordName)
+			page.Malformed = append(page.Malformed, usecase.MalformedRecord{
+				RecordName: p.RecordName,
+				RecordType: "CPLAsset",
+				Reason:     err.Error(),
+			})
 			continue
 		}
 
-		result = append(result, v)
+		page.Photos = append(page.Photos, v)
+	}
+
+	for _, m := range page.Malformed {
+		slog.WarnContext(ctx, "Quarantined record",
+			slog.String("recordName", m.RecordName),
+			slog.String("reason", m.Reason))
 	}
 
-	return result
+	return page
 }
 
 func (p *photoService) MakeDownloadUrlByPhotos(ctx context.Context, photos []usecase.Photo) (string, error) {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 
-	httpClient, _, appleInfo, _ := MetaData(ctx)
-
 	var ids []string
 	for _, p := range photos {
 		ids = append(ids, p.ID)
 	}
 
-	url := util.MustParseUrl(
-		appleInfo.WebServiceSckdatabasewsUrl+"/database/1/com.apple.photos.cloud/production/private/records/zip/prepare",
-		map[string]string{
-			"remapEnums":          "True",
-			"getCurrentSyncToken": "True",
-		},
-	)
-	body := bytes.NewBuffer(util.MustMarshal(map[string]any{
+	resp, err := cloudKit(ctx).Post(ctx, "records/zip/prepare", map[string]any{
 		"includeRecords": ids,
 		"archiveName":    "icloud" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + util.Hash(strings.Join(ids, ",")) + ".zip",
 		"zoneID": map[string]any{
@@ -215,50 +447,154 @@ func (p *photoService) MakeDownloadUrlByPhotos(ctx context.Context, photos []use
 		"pluginFields": map[string]any{
 			"originalsOnly": map[string]any{
 				"value": 1,
-				"type":  "INT64",
+				"type":  infracloudkit.TypeInt64,
 			},
 			"codecs": map[string]any{
 				"value": []string{"HEVC", "H.264"},
-				"type":  "STRING_LIST",
+				"type":  infracloudkit.TypeStringList,
 			},
 			"itemTypes": map[string]any{
 				"value": []string{"public.heic", "public.jpeg", "public.png", "com.compuserve.gif", "com.apple.m4v-video", "com.apple.quicktime-movie", "public.mpeg-4"},
-				"type":  "STRING_LIST",
+				"type":  infracloudkit.TypeStringList,
 			},
 		},
-	}))
-	headers := map[string]string{
-		"Content-Type": "text/plain;charset=UTF-8",
-		"Accept":       "*/*",
-		"Connection":   "keep-alive",
-		"Origin":       "https://www.icloud.com",
-		"Referer":      "https://www.icloud.com/",
-		"User-Agent":   util.UserAgent,
-	}
-
-	resp, err := util.HttpDoJSON[map[string]any](httpClient, util.MustRequest(ctx, http.MethodPost, url, body, headers))
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to zip request: %w", err)
 	}
 
-	return (*resp)["downloadURL"].(string), nil
+	downloadURL, ok := resp["downloadURL"].(string)
+	if !ok {
+		return "", errors.New("missing downloadURL in zip response")
+	}
+
+	return downloadURL, nil
 }
 
 func cnvPhoto(photo Photo) (usecase.Photo, error) {
-	filenameBase64 := photo.MasterFields["filenameEnc"].(map[string]any)["value"].(string)
-	decodedBytes, err := base64.StdEncoding.DecodeString(filenameBase64)
+	filename, err := photo.MasterFields.EncryptedBytes("filenameEnc")
+	if err != nil {
+		return usecase.Photo{}, err
+	}
+
+	original, err := photo.MasterFields.Asset("resOriginalRes")
+	if err != nil {
+		return usecase.Photo{}, err
+	}
+
+	itemType, err := infracloudkit.Optional(photo.MasterFields.String("itemType"))
 	if err != nil {
 		return usecase.Photo{}, err
 	}
 
-	resOriginalRes := photo.MasterFields["resOriginalRes"].(map[string]any)
-	resOriginalResValue := resOriginalRes["value"].(map[string]any)
-	fileSize, _ := resOriginalResValue["size"].(float64)
-	return usecase.Photo{
+	resources, err := cnvResources(photo.MasterFields)
+	if err != nil {
+		return usecase.Photo{}, err
+	}
+
+	alternate, err := cnvResource(photo.MasterFields, "OriginalAlt")
+	if err != nil {
+		return usecase.Photo{}, err
+	}
+
+	burstId, err := infracloudkit.Optional(photo.Fields.String("burstId"))
+	if err != nil {
+		return usecase.Photo{}, err
+	}
+
+	result := usecase.Photo{
 		ID:          photo.RecordName,
-		CheckSum:    resOriginalResValue["fileChecksum"].(string),
-		DownloadUrl: resOriginalResValue["downloadURL"].(string),
-		Filename:    string(decodedBytes),
-		FileSize:    fileSize,
+		CheckSum:    original.FileChecksum,
+		DownloadUrl: original.DownloadURL,
+		Filename:    string(filename),
+		FileSize:    original.Size,
+		ItemType:    itemType,
+		Resources:   resources,
+		Alternate:   alternate,
+		BurstID:     burstId,
+	}
+
+	var isKeyAsset int64
+	for name, dst := range map[string]*int64{
+		"burstFlags":     &result.BurstFlags,
+		"isKeyAsset":     &isKeyAsset,
+		"assetSubtype":   &result.AssetSubtype,
+		"assetSubtypeV2": &result.AssetSubtypeV2,
+		"assetHDRType":   &result.AssetHDRType,
+	} {
+		if *dst, err = infracloudkit.Optional(photo.Fields.Int64(name)); err != nil {
+			return usecase.Photo{}, err
+		}
+	}
+	result.IsKeyAsset = isKeyAsset == 1
+
+	return result, nil
+}
+
+func cnvResources(fields infracloudkit.Fields) (map[usecase.ResourceKind]usecase.PhotoResource, error) {
+	resources := map[usecase.ResourceKind]usecase.PhotoResource{}
+	for _, kind := range usecase.ResourceKinds {
+		res, err := cnvResource(fields, string(kind))
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			resources[kind] = *res
+		}
+	}
+
+	return resources, nil
+}
+
+func cnvResource(fields infracloudkit.Fields, name string) (*usecase.PhotoResource, error) {
+	asset, err := fields.Asset("res" + name + "Res")
+	if errors.Is(err, infracloudkit.ErrFieldNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if asset.DownloadURL == "" {
+		return nil, nil
+	}
+
+	fileType, err := infracloudkit.Optional(fields.String("res" + name + "FileType"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &usecase.PhotoResource{
+		CheckSum:    asset.FileChecksum,
+		DownloadUrl: asset.DownloadURL,
+		FileType:    fileType,
+		FileSize:    asset.Size,
 	}, nil
 }
+
+func malformedRecord(rec infracloudkit.Record, err error) usecase.MalformedRecord {
+	return usecase.MalformedRecord{
+		RecordName: rec.RecordName,
+		RecordType: rec.RecordType,
+		Reason:     err.Error(),
+	}
+}
+
+// 認証エラーなどはリトライしても回復しない
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if util.RetriedByTransport(err) {
+		return false
+	}
+
+	// ステータスが200や400でも本文で混雑を返すことがある
+	var ckErr *infracloudkit.Error
+	if errors.As(err, &ckErr) {
+		return infracloudkit.IsRetryable(err) && !util.RetryableStatus(ckErr.StatusCode)
+	}
+
+	// 本文の読み込み途中で切れたものなど
+	return true
+}
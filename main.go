@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -19,8 +20,9 @@ func init() {
 
 	appctx.InitConfig(appDir)
 	appctx.InitCookies(appDir)
+	appctx.InitJobs(appDir)
 	if aop.IsDebug() {
-		appctx.InitLogger(os.Stdout, slog.LevelInfo)
+		appctx.InitLogger(os.Stdout, slog.LevelDebug)
 	} else {
 		file, err := os.OpenFile(filepath.Join(appDir, "log.txt"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0777)
 		if err != nil {
@@ -32,9 +34,23 @@ func init() {
 
 func main() {
 	icloud := infraicloud.NewICloud()
-	downloader := ifstorelocal.NewDownloader()
+	var downloader usecase.Downloader = ifstorelocal.NewDownloader()
+	if appctx.Config(appctx.NewAppContext()).Downloader == appctx.DownloaderSegmented {
+		downloader = ifstorelocal.NewSegmentedDownloader()
+	}
+
+	if err := appctx.NetworkConfigError(); err != nil {
+		slog.Error("Network is disabled until the config is fixed", slog.String("error", err.Error()))
+		fmt.Fprintln(os.Stderr, err)
+	}
 
 	ucase := usecase.NewUseCase(icloud, downloader)
+	if runCommand(os.Args[1:], ucase) {
+		return
+	}
+	if err := appctx.MarkInterruptedJobs(); err != nil {
+		slog.Error("Failed to mark interrupted jobs", slog.String("error", err.Error()))
+	}
 
 	app := infraui.NewApp(ucase)
 
@@ -0,0 +1,31 @@
+package usecase
+
+// burstFlagsはPHAssetBurstSelectionTypeと同じビット
+const (
+	BurstSelectionAutoPick int64 = 1 << 0
+	BurstSelectionUserPick int64 = 1 << 1
+)
+
+func (p Photo) IsBurst() bool {
+	return p.BurstID != ""
+}
+
+func (p Photo) IsBurstPick() bool {
+	return p.IsKeyAsset || p.BurstFlags&(BurstSelectionAutoPick|BurstSelectionUserPick) != 0
+}
+
+func filterBurstPicks(photos []Photo) []Photo {
+	var result []Photo
+	for _, p := range photos {
+		if p.IsBurst() && !p.IsBurstPick() {
+			continue
+		}
+		result = append(result, p)
+	}
+
+	return result
+}
+
+func burstDir(p Photo) string {
+	return "burst_" + p.BurstID
+}
@@ -0,0 +1,498 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+)
+
+// バイト数で分けるがzip/prepareに渡す件数の上限
+const zipMaxCount = 1000
+
+type (
+	downloadRun struct {
+		u               *useCase
+		dir             string
+		opts            DownloadOptions
+		workers         int
+		zipBatchBytes   float64
+		directThreshold float64
+
+		checkSums     map[string]string
+		seen          map[string]struct{}
+		completed     map[string]struct{}
+		zipQueue      []Photo
+		zipQueueBytes float64
+
+		failedMu sync.Mutex
+		failed   map[string]RetryEntry
+
+		cancel    context.CancelCauseFunc
+		zipChunks chan zipChunk
+		zipSeq    int
+		zipWg     sync.WaitGroup
+	}
+	zipChunk struct {
+		key    string
+		photos []Photo
+	}
+	preparedZip struct {
+		zipChunk
+		url string
+	}
+)
+
+func newDownloadRun(u *useCase, dir string, opts DownloadOptions, config appctx.ConfigFile) *downloadRun {
+	return &downloadRun{
+		u:               u,
+		dir:             dir,
+		opts:            opts,
+		workers:         max(config.MaxParallel, 1),
+		zipBatchBytes:   float64(config.ZipBatchSize()),
+		directThreshold: float64(config.DirectThreshold()),
+		checkSums:       map[string]string{},
+		seen:            map[string]struct{}{},
+		completed:       map[string]struct{}{},
+		failed:          map[string]RetryEntry{},
+	}
+}
+
+// 同じフォルダへの前回の続きなら、manifestにあるものは飛ばす
+func (r *downloadRun) resume(ctx context.Context) error {
+	entries, err := r.u.downloader.LoadManifest(ctx, r.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.Archived {
+			r.checkSums[e.Filename] = e.CheckSum
+		}
+		if e.Alternate {
+			continue
+		}
+		r.completed[completedKey(e.ID, e.CheckSum)] = struct{}{}
+	}
+	if len(r.completed) > 0 {
+		slog.InfoContext(ctx, "Resume", slog.Int("completed", len(r.completed)))
+	}
+
+	return nil
+}
+
+func completedKey(id, checkSum string) string {
+	return id + "/" + checkSum
+}
+
+func (r *downloadRun) handlePage(ctx context.Context, page PhotoPage) error {
+	if len(page.Malformed) > 0 {
+		slog.WarnContext(ctx, "Skip malformed records", slog.Int("count", len(page.Malformed)))
+		if err := r.u.downloader.AppendQuarantine(ctx, r.dir, page.Malformed); err != nil {
+			return err
+		}
+	}
+
+	photos := page.Photos
+	if r.opts.BurstPicksOnly {
+		photos = filterBurstPicks(photos)
+	}
+	photos = excludeMediaClasses(photos, r.opts.ExcludeClasses)
+
+	var (
+		directPhotos []Photo
+		resumedFiles int
+		resumedBytes float64
+	)
+	for _, p := range photos {
+		direct := r.routeDirect(&p)
+		if _, ok := r.completed[completedKey(p.ID, p.CheckSum)]; ok {
+			resumedFiles++
+			resumedBytes += p.FileSize
+			continue
+		}
+		if direct {
+			directPhotos = append(directPhotos, p)
+			continue
+		}
+
+		if len(r.zipQueue) > 0 && (r.zipQueueBytes+p.FileSize > r.zipBatchBytes || len(r.zipQueue) >= zipMaxCount) {
+			if err := r.flushZipQueue(ctx); err != nil {
+				return err
+			}
+		}
+		r.zipQueue = append(r.zipQueue, p)
+		r.zipQueueBytes += p.FileSize
+		// 同じ名前の直接ダウンロードで上書きしない
+		if _, exists := r.checkSums[p.Filename]; !exists {
+			r.checkSums[p.Filename] = p.CheckSum
+		}
+	}
+
+	if p, ok := appctx.Progress(ctx); ok && resumedFiles > 0 {
+		p.AddDone(resumedFiles, resumedBytes)
+	}
+
+	return r.downloadDirect(ctx, directPhotos)
+}
+
+func (r *downloadRun) flushZipQueue(ctx context.Context) error {
+	if len(r.zipQueue) == 0 {
+		return nil
+	}
+
+	photos := r.zipQueue
+	r.zipQueue = nil
+	r.zipQueueBytes = 0
+	return r.enqueueZip(ctx, photos)
+}
+
+func (r *downloadRun) finish(ctx context.Context) error {
+	if err := r.flushZipQueue(ctx); err != nil {
+		return err
+	}
+
+	close(r.zipChunks)
+	r.zipWg.Wait()
+
+	return context.Cause(ctx)
+}
+
+func (r *downloadRun) stop(err error) {
+	r.cancel(err)
+	r.zipWg.Wait()
+}
+
+func (r *downloadRun) routeDirect(p *Photo) bool {
+	// zipはオリジナルしか含まないので全て直接ダウンロード
+	if r.opts.Rendition != RenditionOriginal && r.opts.Rendition != "" {
+		*p = p.WithRendition(r.opts.Rendition)
+		return true
+	}
+
+	if r.opts.Strategy == StrategyDirect {
+		return true
+	}
+
+	// 被りは直接ダウンロード
+	if _, exists := r.seen[p.CheckSum]; exists {
+		return true
+	}
+	r.seen[p.CheckSum] = struct{}{}
+
+	// RAW+JPEGは両方を同じ名前で保存、テンプレートでフォルダ分けされるものはzipで保存できない
+	// 使えないパスは直接ダウンロードの方で失敗として記録する
+	if path, err := renderPath(r.opts.PathTemplate, *p); p.Alternate != nil || err != nil || path != p.Filename {
+		return true
+	}
+
+	// 大きいファイルはzipにすると失敗しやすいので、zip指定でも直接ダウンロードする
+	if p.FileSize > r.directThreshold {
+		return true
+	}
+	if r.opts.Strategy == StrategyZip {
+		return false
+	}
+
+	// 動画もzipにすると失敗しやすい
+	return p.IsVideo()
+}
+
+func (r *downloadRun) downloadDirect(ctx context.Context, photos []Photo) error {
+	if len(photos) == 0 {
+		return nil
+	}
+
+	requests, entries := r.directRequests(ctx, photos)
+	if p, ok := appctx.Progress(ctx); ok {
+		p.AddTotal(len(requests), totalFileSize(requests))
+		for _, req := range requests {
+			p.QueueFile(req.Key, req.Filename, req.PhotoIDs)
+		}
+	}
+	failed, cause := r.u.downloader.DownloadFileUrls(ctx, r.dir, requests, r.workers)
+	return r.appendManifest(ctx, r.recordFailures(ctx, failed, entries, cause), cause)
+}
+
+// 止められた場合も終わったファイルはmanifestに書いてから理由を返す
+func (r *downloadRun) appendManifest(ctx context.Context, entries []ManifestEntry, cause error) error {
+	if err := r.u.downloader.AppendManifest(ctx, r.dir, entries); err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// 失敗したファイルの写真はmanifestに書かずに再試行の一覧に回す
+// 止めたことで終わらなかったものは失敗ではないので、再試行の一覧には入れない
+func (r *downloadRun) recordFailures(ctx context.Context, failed []FailedFile, entries []ManifestEntry, cause error) []ManifestEntry {
+	if len(failed) == 0 {
+		return entries
+	}
+
+	failedIDs := map[string]struct{}{}
+	for _, f := range failed {
+		if cause == nil || !(errors.Is(f.Err, cause) || errors.Is(f.Err, context.Canceled)) {
+			r.fail(ctx, f.File.PhotoIDs, f.File.Filename, f.Err)
+		}
+		for _, id := range f.File.PhotoIDs {
+			failedIDs[id] = struct{}{}
+		}
+	}
+
+	result := make([]ManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		if _, ok := failedIDs[e.ID]; !ok {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func (r *downloadRun) fail(ctx context.Context, ids []string, filename string, err error) {
+	r.failedMu.Lock()
+	defer r.failedMu.Unlock()
+
+	slog.WarnContext(ctx, "Download failed", slog.Int("photos", len(ids)), slog.String("filename", filename), slog.String("error", err.Error()))
+	for _, id := range ids {
+		r.failed[id] = RetryEntry{ID: id, Filename: filename, Reason: err.Error()}
+	}
+}
+
+func (r *downloadRun) retryEntries() []RetryEntry {
+	r.failedMu.Lock()
+	defer r.failedMu.Unlock()
+
+	entries := make([]RetryEntry, 0, len(r.failed))
+	for _, e := range r.failed {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b RetryEntry) int { return strings.Compare(a.ID, b.ID) })
+	return entries
+}
+
+// zipの準備はダウンロード中に先に進めておく
+// 直接ダウンロードとzipは同じ接続数の枠を使い、合わせてMaxParallelを超えないようにする
+func (r *downloadRun) start(ctx context.Context) context.Context {
+	ctx, r.cancel = context.WithCancelCause(ctx)
+	ctx = appctx.WithDownloadSlots(ctx, r.workers)
+	r.zipChunks = make(chan zipChunk, r.workers)
+	prepared := make(chan preparedZip, r.workers)
+
+	var prepareWg sync.WaitGroup
+	for range r.workers {
+		prepareWg.Add(1)
+		go func() {
+			defer prepareWg.Done()
+			for {
+				var chunk zipChunk
+				select {
+				case c, ok := <-r.zipChunks:
+					if !ok {
+						return
+					}
+					chunk = c
+				case <-ctx.Done():
+					return
+				}
+
+				// 一時停止中に作ったURLは期限が切れるので再開まで待つ
+				if err := appctx.WaitResume(ctx); err != nil {
+					return
+				}
+				url, err := r.u.iCloudService.MakeDownloadUrlByPhotos(ctx, chunk.photos)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					if p, ok := appctx.Progress(ctx); ok {
+						p.UpdateFile(chunk.key, appctx.FileFailed, "", err)
+					}
+					r.fail(ctx, photoIDs(chunk.photos), "", err)
+					continue
+				}
+
+				select {
+				case prepared <- preparedZip{zipChunk: chunk, url: url}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	r.zipWg.Add(2)
+	go func() {
+		defer r.zipWg.Done()
+		prepareWg.Wait()
+		close(prepared)
+	}()
+	go func() {
+		defer r.zipWg.Done()
+		for zip := range prepared {
+			batch := []preparedZip{zip}
+		fill:
+			for len(batch) < r.workers {
+				select {
+				case z, ok := <-prepared:
+					if !ok {
+						break fill
+					}
+					batch = append(batch, z)
+				default:
+					break fill
+				}
+			}
+
+			if ctx.Err() != nil {
+				continue
+			}
+			if err := r.downloadZip(ctx, batch); err != nil {
+				r.cancel(err)
+			}
+		}
+	}()
+
+	return ctx
+}
+
+func (r *downloadRun) enqueueZip(ctx context.Context, photos []Photo) error {
+	r.zipSeq++
+	chunk := zipChunk{key: fmt.Sprintf("zip-%d", r.zipSeq), photos: photos}
+	if p, ok := appctx.Progress(ctx); ok {
+		size := float64(0)
+		for _, photo := range photos {
+			size += photo.FileSize
+		}
+		p.AddTotal(1, size)
+		p.QueueFile(chunk.key, "", photoIDs(photos))
+	}
+
+	select {
+	case r.zipChunks <- chunk:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (r *downloadRun) downloadZip(ctx context.Context, zips []preparedZip) error {
+	slog.InfoContext(ctx, "Download Zip", slog.Int("count", len(zips)))
+	requests := []FileUrl{}
+	entries := []ManifestEntry{}
+	for _, z := range zips {
+		req := FileUrl{Key: z.key, Url: z.url, FileSize: 0, PhotoIDs: photoIDs(z.photos)}
+		for _, fs := range z.photos {
+			req.FileSize += fs.FileSize
+		}
+		requests = append(requests, req)
+		entries = append(entries, archivedEntries(z.photos)...)
+	}
+
+	failed, cause := r.u.downloader.DownloadFileUrls(ctx, r.dir, requests, r.workers)
+	return r.appendManifest(ctx, r.recordFailures(ctx, failed, entries, cause), cause)
+}
+
+func (r *downloadRun) directRequests(ctx context.Context, photos []Photo) ([]FileUrl, []ManifestEntry) {
+	var (
+		requests []FileUrl
+		entries  []ManifestEntry
+	)
+
+	for _, p := range photos {
+		path, err := renderPath(r.opts.PathTemplate, p)
+		if err != nil {
+			r.fail(ctx, []string{p.ID}, p.Filename, err)
+			continue
+		}
+		filename, queued := uniqueFilename(r.checkSums, path, p.CheckSum)
+		if queued {
+			// 同じ名前で同じ中身のファイルは取得済みか取得中なので、二重にダウンロードしない
+			slog.DebugContext(ctx, "Skip duplicate", slog.String("id", p.ID), slog.String("filename", filename))
+			continue
+		}
+		requests = append(requests, FileUrl{
+			Key:      p.ID,
+			Url:      p.DownloadUrl,
+			Filename: filename,
+			FileSize: p.FileSize,
+			PhotoIDs: []string{p.ID},
+		})
+		entry := ManifestEntry{
+			ID:       p.ID,
+			Filename: filename,
+			CheckSum: p.CheckSum,
+			FileSize: p.FileSize,
+			BurstID:  p.BurstID,
+			Class:    p.MediaClass(),
+		}
+
+		if p.Alternate != nil {
+			altFilename, altQueued := uniqueFilename(r.checkSums, alternateFilename(filename, p.Alternate.FileType), p.Alternate.CheckSum)
+			entry.PairedWith = altFilename
+			if !altQueued {
+				requests = append(requests, FileUrl{
+					Key:      p.ID + "/alternate",
+					Url:      p.Alternate.DownloadUrl,
+					Filename: altFilename,
+					FileSize: p.Alternate.FileSize,
+					PhotoIDs: []string{p.ID},
+				})
+				entries = append(entries, ManifestEntry{
+					ID:         p.ID,
+					Filename:   altFilename,
+					CheckSum:   p.Alternate.CheckSum,
+					FileSize:   p.Alternate.FileSize,
+					Alternate:  true,
+					PairedWith: filename,
+					BurstID:    p.BurstID,
+					Class:      p.MediaClass(),
+				})
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return requests, entries
+}
+
+func photoIDs(photos []Photo) []string {
+	ids := make([]string, 0, len(photos))
+	for _, p := range photos {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func totalFileSize(files []FileUrl) float64 {
+	size := float64(0)
+	for _, f := range files {
+		size += f.FileSize
+	}
+	return size
+}
+
+// 同名で中身が違うファイルは上書きしない
+// 同名で同じ中身のファイルが既にある場合はtrueを返す
+func uniqueFilename(checkSums map[string]string, filename, checkSum string) (string, bool) {
+	ext := filepath.Ext(filename)
+	result := filename
+	for n := 1; ; n++ {
+		sum, exists := checkSums[result]
+		if !exists {
+			break
+		}
+		if sum == checkSum {
+			return result, true
+		}
+		result = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(filename, ext), n, ext)
+	}
+	checkSums[result] = checkSum
+
+	return result, false
+}
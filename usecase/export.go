@@ -0,0 +1,25 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+)
+
+// ダウンロード済みの一覧を表計算ソフトなどで見られるようにCSVで書き出す
+func (u *useCase) ExportManifest(ctx context.Context, dir, dest string) error {
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+
+	entries, err := u.downloader.LoadManifest(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Export", slog.String("dir", dir), slog.String("dest", dest), slog.Int("entries", len(entries)))
+
+	return u.downloader.WriteManifestCSV(ctx, dest, entries)
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+)
+
+type MalformedRecord struct {
+	RecordName string `json:"recordName"`
+	RecordType string `json:"recordType"`
+	Reason     string `json:"reason"`
+}
+
+type ManifestEntry struct {
+	ID         string     `json:"id"`
+	Filename   string     `json:"filename"`
+	CheckSum   string     `json:"checkSum"`
+	FileSize   float64    `json:"fileSize"`
+	Alternate  bool       `json:"alternate,omitempty"`
+	PairedWith string     `json:"pairedWith,omitempty"`
+	BurstID    string     `json:"burstId,omitempty"`
+	Class      MediaClass `json:"class,omitempty"`
+	Archived   bool       `json:"archived,omitempty"`
+}
+
+func archivedEntries(photos []Photo) []ManifestEntry {
+	entries := make([]ManifestEntry, 0, len(photos))
+	for _, p := range photos {
+		entries = append(entries, ManifestEntry{
+			ID:       p.ID,
+			Filename: p.Filename,
+			CheckSum: p.CheckSum,
+			FileSize: p.FileSize,
+			Class:    p.MediaClass(),
+			Archived: true,
+		})
+	}
+
+	return entries
+}
+
+type RetryEntry struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+type RunReport struct {
+	StartedAt  time.Time           `json:"startedAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	Error      string              `json:"error,omitempty"`
+	Done       int                 `json:"done"`
+	Failed     int                 `json:"failed"`
+	Files      []appctx.FileStatus `json:"files"` // 完了したものは件数だけ、失敗と終わらなかったものを入れる
+}
+
+func newRunReport(startedAt time.Time, files []appctx.FileStatus, counts appctx.FileCounts, err error) RunReport {
+	report := RunReport{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Done:       counts.Done,
+		Failed:     counts.Failed,
+		Files:      files,
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	return report
+}
@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type MediaClass string
+
+const (
+	MediaClassScreenshot MediaClass = "screenshot"
+	MediaClassPanorama   MediaClass = "panorama"
+	MediaClassSlomo      MediaClass = "slomo"
+	MediaClassTimelapse  MediaClass = "timelapse"
+	MediaClassPortrait   MediaClass = "portrait"
+	MediaClassHDR        MediaClass = "hdr"
+	MediaClassOther      MediaClass = "other"
+)
+
+// assetSubtypeはPHAssetMediaSubtypeと同じビット
+const (
+	subtypePanorama   int64 = 1 << 0
+	subtypeHDR        int64 = 1 << 1
+	subtypeScreenshot int64 = 1 << 2
+	subtypeDepth      int64 = 1 << 4
+	subtypeSlomo      int64 = 1 << 17
+	subtypeTimelapse  int64 = 1 << 18
+)
+
+const DefaultPathTemplate = "{burst}/{filename}"
+
+func ParseMediaClass(s string) (MediaClass, error) {
+	c := MediaClass(s)
+	switch c {
+	case MediaClassScreenshot, MediaClassPanorama, MediaClassSlomo, MediaClassTimelapse,
+		MediaClassPortrait, MediaClassHDR, MediaClassOther:
+		return c, nil
+	}
+
+	return "", fmt.Errorf("unknown media class: %s", s)
+}
+
+func (p Photo) MediaClass() MediaClass {
+	subtype := p.AssetSubtypeV2
+	if subtype == 0 {
+		subtype = p.AssetSubtype
+	}
+
+	switch {
+	case subtype&subtypeScreenshot != 0:
+		return MediaClassScreenshot
+	case subtype&subtypePanorama != 0:
+		return MediaClassPanorama
+	case subtype&subtypeSlomo != 0:
+		return MediaClassSlomo
+	case subtype&subtypeTimelapse != 0:
+		return MediaClassTimelapse
+	case subtype&subtypeDepth != 0:
+		return MediaClassPortrait
+	case subtype&subtypeHDR != 0, p.AssetHDRType != 0:
+		return MediaClassHDR
+	}
+
+	return MediaClassOther
+}
+
+func excludeMediaClasses(photos []Photo, classes []MediaClass) []Photo {
+	if len(classes) == 0 {
+		return photos
+	}
+
+	excludes := map[MediaClass]struct{}{}
+	for _, c := range classes {
+		excludes[c] = struct{}{}
+	}
+
+	var result []Photo
+	for _, p := range photos {
+		if _, ok := excludes[p.MediaClass()]; ok {
+			continue
+		}
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// テンプレートが出力先の外を指していないかを実行前に確かめる
+func ValidatePathTemplate(tmpl string) error {
+	sample := Photo{Filename: "IMG_0001.JPG", BurstID: "sample"}
+	if _, err := renderPath(tmpl, sample); err != nil {
+		return fmt.Errorf("invalid path template %q: %w", tmpl, err)
+	}
+	return nil
+}
+
+// 出力先の外に出るパスはエラーにする、ファイル名に".."が入っている場合も同じ
+// 使えない文字はテンプレートに書かれていればエラー、写真のファイル名なら"_"にする
+func renderPath(tmpl string, p Photo) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultPathTemplate
+	}
+	if i := strings.IndexFunc(tmpl, isReservedPathRune); i >= 0 {
+		return "", fmt.Errorf("path template contains reserved character %q", tmpl[i])
+	}
+	if strings.ContainsAny(p.Filename, `/\`) || p.Filename == "." || p.Filename == ".." {
+		return "", fmt.Errorf("invalid filename %q", p.Filename)
+	}
+	if !strings.Contains(tmpl, "{filename}") {
+		tmpl += "/{filename}"
+	}
+
+	burst := ""
+	if p.IsBurst() {
+		burst = burstDir(p)
+	}
+
+	path := strings.NewReplacer(
+		"{class}", string(p.MediaClass()),
+		"{burst}", burst,
+		"{filename}", replaceReservedPathRunes(p.Filename),
+	).Replace(tmpl)
+
+	path = strings.TrimLeft(filepath.Clean(filepath.FromSlash(path)), string(filepath.Separator))
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("path %q escapes the output directory", path)
+	}
+	return path, nil
+}
+
+// Windowsでファイル名に使えない文字と制御文字
+func isReservedPathRune(r rune) bool {
+	return r < 0x20 || strings.ContainsRune(`<>:"|?*`, r)
+}
+
+func replaceReservedPathRunes(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isReservedPathRune(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderPath(t *testing.T) {
+	photo := Photo{Filename: "IMG_0001.JPG"}
+	burst := Photo{Filename: "IMG_0002.JPG", BurstID: "b1"}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		photo   Photo
+		want    string
+		wantErr bool
+	}{
+		{name: "default", tmpl: "", photo: photo, want: "IMG_0001.JPG"},
+		{name: "default burst", tmpl: "", photo: burst, want: "burst_b1/IMG_0002.JPG"},
+		{name: "class", tmpl: "{class}/{filename}", photo: photo, want: "other/IMG_0001.JPG"},
+		{name: "filename appended", tmpl: "{class}", photo: photo, want: "other/IMG_0001.JPG"},
+		{name: "empty segments", tmpl: "a//{burst}//{filename}", photo: photo, want: "a/IMG_0001.JPG"},
+		{name: "inner dot dot", tmpl: "a/../{filename}", photo: photo, want: "IMG_0001.JPG"},
+		{name: "absolute made relative", tmpl: "/abs/{filename}", photo: photo, want: "abs/IMG_0001.JPG"},
+		{name: "reserved in filename", tmpl: "", photo: Photo{Filename: `a:b?.JPG`}, want: "a_b_.JPG"},
+
+		{name: "dot dot", tmpl: "../{filename}", photo: photo, wantErr: true},
+		{name: "nested dot dot", tmpl: "a/../../{filename}", photo: photo, wantErr: true},
+		{name: "dot dot in filename", tmpl: "", photo: Photo{Filename: "../../etc/passwd"}, wantErr: true},
+		{name: "filename is dot dot", tmpl: "{class}/{filename}", photo: Photo{Filename: ".."}, wantErr: true},
+		{name: "empty filename", tmpl: "", photo: Photo{}, wantErr: true},
+		{name: "reserved in template", tmpl: "{class}:{filename}", photo: photo, wantErr: true},
+		{name: "control character in template", tmpl: "a\x00/{filename}", photo: photo, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPath(tt.tmpl, tt.photo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("renderPath(%q) = %q, want error", tt.tmpl, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderPath(%q): %v", tt.tmpl, err)
+			}
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("renderPath(%q) = %q, want %q", tt.tmpl, got, want)
+			}
+		})
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		wantErr bool
+	}{
+		{tmpl: ""},
+		{tmpl: "{class}/{burst}/{filename}"},
+		{tmpl: "../{filename}", wantErr: true},
+		{tmpl: "{class}/../../{filename}", wantErr: true},
+		{tmpl: "photos|{filename}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidatePathTemplate(tt.tmpl); (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePathTemplate(%q) = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	Rendition     string
+	ResourceKind  string
+	PhotoResource struct {
+		CheckSum    string
+		DownloadUrl string
+		FileType    string
+		FileSize    float64
+	}
+)
+
+const (
+	RenditionOriginal   Rendition = "original"
+	RenditionCompatible Rendition = "compatible"
+	RenditionMedium     Rendition = "medium"
+	RenditionSmallVideo Rendition = "small_video"
+)
+
+const (
+	ResourceOriginal  ResourceKind = "Original"
+	ResourceJPEGFull  ResourceKind = "JPEGFull"
+	ResourceJPEGLarge ResourceKind = "JPEGLarge"
+	ResourceJPEGMed   ResourceKind = "JPEGMed"
+	ResourceJPEGThumb ResourceKind = "JPEGThumb"
+	ResourceVidFull   ResourceKind = "VidFull"
+	ResourceVidMed    ResourceKind = "VidMed"
+	ResourceVidSmall  ResourceKind = "VidSmall"
+)
+
+var (
+	ResourceKinds = []ResourceKind{
+		ResourceOriginal,
+		ResourceJPEGFull, ResourceJPEGLarge, ResourceJPEGMed, ResourceJPEGThumb,
+		ResourceVidFull, ResourceVidMed, ResourceVidSmall,
+	}
+	// 見つからなければ次の候補、最後はオリジナル
+	renditionCandidates = map[Rendition]struct{ photo, video []ResourceKind }{
+		RenditionOriginal: {
+			photo: []ResourceKind{ResourceOriginal},
+			video: []ResourceKind{ResourceOriginal},
+		},
+		RenditionCompatible: {
+			photo: []ResourceKind{ResourceJPEGFull, ResourceJPEGLarge, ResourceOriginal},
+			video: []ResourceKind{ResourceVidFull, ResourceOriginal},
+		},
+		RenditionMedium: {
+			photo: []ResourceKind{ResourceJPEGMed, ResourceJPEGLarge, ResourceOriginal},
+			video: []ResourceKind{ResourceVidMed, ResourceVidSmall, ResourceOriginal},
+		},
+		RenditionSmallVideo: {
+			photo: []ResourceKind{ResourceOriginal},
+			video: []ResourceKind{ResourceVidSmall, ResourceVidMed, ResourceOriginal},
+		},
+	}
+	fileTypeExts = map[string]string{
+		"public.jpeg":                 ".jpg",
+		"public.heic":                 ".heic",
+		"public.png":                  ".png",
+		"com.compuserve.gif":          ".gif",
+		"com.apple.m4v-video":         ".m4v",
+		"com.apple.quicktime-movie":   ".mov",
+		"public.mpeg-4":               ".mp4",
+		"com.adobe.raw-image":         ".dng",
+		"com.canon.cr2-raw-image":     ".cr2",
+		"com.canon.cr3-raw-image":     ".cr3",
+		"com.nikon.raw-image":         ".nef",
+		"com.sony.arw-raw-image":      ".arw",
+		"com.fuji.raw-image":          ".raf",
+		"com.olympus.raw-image":       ".orf",
+		"com.panasonic.rw2-raw-image": ".rw2",
+	}
+	videoTypes = map[string]struct{}{
+		"com.apple.m4v-video":       {},
+		"com.apple.quicktime-movie": {},
+		"public.mpeg-4":             {},
+	}
+)
+
+func ParseRendition(s string) (Rendition, error) {
+	if s == "" {
+		return RenditionOriginal, nil
+	}
+
+	r := Rendition(s)
+	if _, ok := renditionCandidates[r]; !ok {
+		return "", fmt.Errorf("unknown rendition: %s", s)
+	}
+
+	return r, nil
+}
+
+func (p Photo) IsVideo() bool {
+	_, ok := videoTypes[p.ItemType]
+	return ok
+}
+
+func (p Photo) WithRendition(r Rendition) Photo {
+	candidates, ok := renditionCandidates[r]
+	if !ok {
+		return p
+	}
+	if r != RenditionOriginal {
+		p.Alternate = nil
+	}
+
+	kinds := candidates.photo
+	if p.IsVideo() {
+		kinds = candidates.video
+	}
+
+	for _, kind := range kinds {
+		if kind == ResourceOriginal {
+			return p
+		}
+
+		res, ok := p.Resources[kind]
+		if !ok || res.DownloadUrl == "" {
+			continue
+		}
+
+		p.CheckSum = res.CheckSum
+		p.DownloadUrl = res.DownloadUrl
+		p.FileSize = res.FileSize
+		p.Filename = replaceExt(p.Filename, res.FileType)
+		return p
+	}
+
+	return p
+}
+
+func alternateFilename(filename, fileType string) string {
+	altFilename := replaceExt(filename, fileType)
+	if altFilename == filename {
+		ext := filepath.Ext(filename)
+		altFilename = strings.TrimSuffix(filename, ext) + "_alt" + ext
+	}
+
+	return altFilename
+}
+
+func replaceExt(filename, fileType string) string {
+	ext, ok := fileTypeExts[fileType]
+	if !ok {
+		return filename
+	}
+
+	oldExt := filepath.Ext(filename)
+	if oldExt != "" && oldExt == strings.ToUpper(oldExt) {
+		ext = strings.ToUpper(ext)
+	}
+
+	return strings.TrimSuffix(filename, oldExt) + ext
+}
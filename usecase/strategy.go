@@ -0,0 +1,33 @@
+package usecase
+
+import "fmt"
+
+type Strategy string
+
+const (
+	StrategyAuto   Strategy = "auto"
+	StrategyZip    Strategy = "zip"
+	StrategyDirect Strategy = "direct"
+)
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "":
+		return StrategyAuto, nil
+	case StrategyAuto, StrategyZip, StrategyDirect:
+		return Strategy(s), nil
+	}
+
+	return "", fmt.Errorf("unknown strategy: %s", s)
+}
+
+func (s Strategy) Phase() string {
+	switch s {
+	case StrategyZip:
+		return "DOWNLOAD_ZIP"
+	case StrategyDirect:
+		return "DOWNLOAD_DIRECT"
+	}
+
+	return "DOWNLOAD"
+}
@@ -5,10 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"runtime"
+	"time"
 
 	"github.com/take0244/go-icloud-photo-gui/appctx"
-	"github.com/take0244/go-icloud-photo-gui/util"
 )
 
 type (
@@ -18,31 +17,86 @@ type (
 		DownloadUrl string
 		Filename    string
 		FileSize    float64
+		ItemType    string
+		Resources   map[ResourceKind]PhotoResource
+		Alternate   *PhotoResource
+		BurstID     string
+		BurstFlags  int64
+		IsKeyAsset  bool
+
+		AssetSubtype   int64
+		AssetSubtypeV2 int64
+		AssetHDRType   int64
 	}
 	ICloudService interface {
 		Login(ctx context.Context, username, password string) (bool, error)
 		Code2fa(ctx context.Context, code string) error
-		GetAllPhotos(ctx context.Context) []Photo
+		// 保存済みのcookieでログインし直す、使えなければErrSessionExpired
+		RestoreSession(ctx context.Context) error
+		StreamPhotos(ctx context.Context) <-chan PhotoPage
+		LookupPhotos(ctx context.Context, ids []string) PhotoPage
 		MakeDownloadUrlByPhotos(ctx context.Context, photos []Photo) (string, error)
 	}
+	PhotoPage struct {
+		Photos    []Photo
+		Malformed []MalformedRecord
+		Err       error
+	}
+	// Keyは進捗と状態の報告に使う、PhotoIDsは中に含まれる写真
 	FileUrl struct {
+		Key      string
 		Url      string
 		Filename string
 		FileSize float64
+		PhotoIDs []string
+	}
+	FailedFile struct {
+		File FileUrl
+		Err  error
 	}
 	Downloader interface {
-		DownloadFileUrls(ctx context.Context, dir string, urls []FileUrl, workers int) error
+		// 個別のファイルの失敗はFailedFileで返し、止まった場合だけerrorを返す
+		// 止まった場合も終わっていないファイルはFailedFileに含める
+		DownloadFileUrls(ctx context.Context, dir string, urls []FileUrl, workers int) ([]FailedFile, error)
+		AppendManifest(ctx context.Context, dir string, entries []ManifestEntry) error
+		LoadManifest(ctx context.Context, dir string) ([]ManifestEntry, error)
+		WriteReport(ctx context.Context, dir string, report RunReport) error
+		LoadRetryList(ctx context.Context, dir string) ([]RetryEntry, error)
+		WriteRetryList(ctx context.Context, dir string, entries []RetryEntry) error
+		AppendQuarantine(ctx context.Context, dir string, records []MalformedRecord) error
+		// 保存済みのファイルが無いかサイズが違えばエラー
+		VerifyFile(ctx context.Context, dir string, entry ManifestEntry) error
+		WriteManifestCSV(ctx context.Context, path string, entries []ManifestEntry) error
 	}
 )
 
+var (
+	ErrCanceled   = errors.New("download canceled")
+	ErrSomeFailed = errors.New("some photos failed")
+	// 保存済みのcookieが無いか期限切れ、ログインし直す必要がある
+	ErrSessionExpired = errors.New("session expired")
+)
+
 type (
 	LoginResult struct {
 		Required2fa bool
 	}
+	DownloadOptions struct {
+		Rendition      Rendition
+		Strategy       Strategy
+		BurstPicksOnly bool
+		ExcludeClasses []MediaClass
+		PathTemplate   string
+	}
 	UseCase interface {
 		Login(ctx context.Context, username, password string) (*LoginResult, error)
 		Code2fa(ctx context.Context, code string) error
-		DownloadAllPhotos(ctx context.Context, dir string) error
+		DownloadAllPhotos(ctx context.Context, dir string, opts DownloadOptions) error
+		ScheduledDownload(ctx context.Context, dir string, opts DownloadOptions) error
+		RetryFailedPhotos(ctx context.Context, dir string, opts DownloadOptions) error
+		RetryCount(ctx context.Context, dir string) (int, error)
+		VerifyDownloads(ctx context.Context, dir string) error
+		ExportManifest(ctx context.Context, dir, dest string) error
 	}
 	useCase struct {
 		iCloudService ICloudService
@@ -76,98 +130,110 @@ func (u *useCase) Code2fa(ctx context.Context, code string) error {
 	return u.iCloudService.Code2fa(ctx, code)
 }
 
-func (u *useCase) DownloadAllPhotos(ctx context.Context, dir string) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]uintptr, 10)
-			n := runtime.Callers(2, buf)
-			frames := runtime.CallersFrames(buf[:n])
-			result := ""
-			for frame, more := frames.Next(); more; frame, more = frames.Next() {
-				result += fmt.Sprintf("  - %s\n    %s:%d\n", frame.Function, frame.File, frame.Line)
-			}
+func (u *useCase) DownloadAllPhotos(ctx context.Context, dir string, opts DownloadOptions) error {
+	return u.download(ctx, dir, opts, u.iCloudService.StreamPhotos)
+}
 
-			slog.ErrorContext(ctx, "Panic",
-				slog.Any("🔥 Panic Recovered:", r),
-				slog.String("📌 Stack Trace:", result),
-			)
-			err = errors.New(result)
-		}
-	}()
+// ログイン画面を通さずに動くため、保存済みのcookieでセッションを戻してから差分をダウンロードする
+func (u *useCase) ScheduledDownload(ctx context.Context, dir string, opts DownloadOptions) error {
+	if err := u.iCloudService.RestoreSession(ctx); err != nil {
+		return err
+	}
+
+	return u.DownloadAllPhotos(ctx, dir, opts)
+}
+
+// 前回失敗した写真だけを、新しいURLを取り直してダウンロードする
+func (u *useCase) RetryFailedPhotos(ctx context.Context, dir string, opts DownloadOptions) error {
+	entries, err := u.downloader.LoadRetryList(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	slog.InfoContext(ctx, "Retry failed photos", slog.Int("count", len(ids)))
+
+	return u.download(ctx, dir, opts, func(ctx context.Context) <-chan PhotoPage {
+		ch := make(chan PhotoPage, 1)
+		ch <- u.iCloudService.LookupPhotos(ctx, ids)
+		close(ch)
+		return ch
+	})
+}
+
+// 前回失敗して再試行を待っている写真の数
+func (u *useCase) RetryCount(ctx context.Context, dir string) (int, error) {
+	entries, err := u.downloader.LoadRetryList(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+func (u *useCase) download(ctx context.Context, dir string, opts DownloadOptions, source func(context.Context) <-chan PhotoPage) (err error) {
 	appctx.AppTrace(ctx)
 	defer appctx.DeferAppTrace(ctx)
 	p, okProgress := appctx.Progress(ctx)
 	config := appctx.Config(ctx)
 
 	if okProgress {
-		p.SetPhase("CHECK_FILES", 1)
+		p.SetPhase(opts.Strategy.Phase())
+		startedAt := time.Now()
+		defer func() {
+			report := newRunReport(startedAt, p.FileStatuses(), p.FileCounts(), err)
+			if werr := u.downloader.WriteReport(ctx, dir, report); werr != nil {
+				slog.WarnContext(ctx, "Failed to write report", slog.String("error", werr.Error()))
+			}
+		}()
 	}
-	photos, duplicatePhotos := u.splitDuplicateCheckSum(u.iCloudService.GetAllPhotos(ctx))
-	chunkedPhotos := util.ChunkSlice(photos, 1000)
-
-	// 被りをダウンロード
-	if okProgress {
-		p.SetPhase("DOWNLOAD_DUPLICATE", float64(len(duplicatePhotos)))
-	}
-	slog.InfoContext(ctx, "Start Duplicate")
-	depRequests := []FileUrl{}
-	for i, photo := range duplicatePhotos {
-		slog.InfoContext(ctx, "Duplicate Index", slog.Int("index", i))
-		depRequests = append(depRequests, FileUrl{
-			Url:      photo.DownloadUrl,
-			Filename: photo.Filename,
-			FileSize: photo.FileSize,
-		})
-	}
-	if err := u.downloader.DownloadFileUrls(ctx, dir, depRequests, config.MaxParallel); err != nil {
+	run := newDownloadRun(u, dir, opts, config)
+	if err := run.resume(ctx); err != nil {
 		return err
 	}
+	defer func() {
+		// 最後まで進んだ場合だけ書き換える、途中で止まった場合は前回の一覧を残す
+		if err != nil && !errors.Is(err, ErrSomeFailed) {
+			return
+		}
+		if werr := u.downloader.WriteRetryList(ctx, dir, run.retryEntries()); werr != nil {
+			slog.WarnContext(ctx, "Failed to write retry list", slog.String("error", werr.Error()))
+		}
+	}()
+	ctx = run.start(ctx)
+	defer run.stop(context.Canceled)
+	defer func() {
+		// 停止された場合は途中のエラーではなく停止理由を返す
+		if cause := context.Cause(ctx); err != nil && cause != nil {
+			err = cause
+		}
+	}()
 
-	// 全てダウンロード
-	if okProgress {
-		p.SetPhase("DOWNLOAD_ZIP", float64(len(chunkedPhotos)))
-	}
-	slog.InfoContext(ctx, "Start Zip")
-	for i, chunked := range util.ChunkSlice(chunkedPhotos, config.MaxParallel) {
-		slog.InfoContext(ctx, "Zip Index", slog.Int("index", i))
-		requests := []FileUrl{}
-		for _, v := range chunked {
-			url, err := u.iCloudService.MakeDownloadUrlByPhotos(ctx, v)
-			if err != nil {
-				return err
-			}
-
-			req := FileUrl{Url: url, FileSize: 0}
-			for _, fs := range v {
-				req.FileSize += fs.FileSize
-			}
-			requests = append(requests, req)
-
+	for page := range source(ctx) {
+		if page.Err != nil {
+			// 一部だけの一覧で終わらせない
+			return fmt.Errorf("failed to list photos: %w", page.Err)
 		}
-		if err := u.downloader.DownloadFileUrls(ctx, dir, requests, config.MaxParallel); err != nil {
+
+		if err := run.handlePage(ctx, page); err != nil {
 			return err
 		}
 	}
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
 
-	return nil
-}
-
-func (u *useCase) splitDuplicateCheckSum(photos []Photo) ([]Photo, []Photo) {
-	var (
-		original  []Photo
-		duplicate []Photo
-		dupMap    = map[string]struct{}{}
-	)
-
-	for _, p := range photos {
-		_, exits := dupMap[p.CheckSum]
-		if exits {
-			duplicate = append(duplicate, p)
-		} else {
-			original = append(original, p)
-			dupMap[p.CheckSum] = struct{}{}
-		}
+	if err := run.finish(ctx); err != nil {
+		return err
+	}
+	if failed := len(run.retryEntries()); failed > 0 {
+		return fmt.Errorf("%d photos failed: %w", failed, ErrSomeFailed)
 	}
 
-	return original, duplicate
+	return nil
 }
@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/take0244/go-icloud-photo-gui/appctx"
+)
+
+var ErrVerifyFailed = errors.New("some files are missing or broken")
+
+// manifestにあるファイルが残っていてサイズが合っているかを確認する
+// zipで落としたものは展開後の名前が分からないので飛ばす
+func (u *useCase) VerifyDownloads(ctx context.Context, dir string) error {
+	appctx.AppTrace(ctx)
+	defer appctx.DeferAppTrace(ctx)
+
+	entries, err := u.downloader.LoadManifest(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	targets := make([]ManifestEntry, 0, len(entries))
+	var size float64
+	for _, e := range entries {
+		if e.Archived {
+			continue
+		}
+		targets = append(targets, e)
+		size += e.FileSize
+	}
+	p, okProgress := appctx.Progress(ctx)
+	if okProgress {
+		p.SetPhase("VERIFY")
+		p.AddTotal(len(targets), size)
+	}
+	slog.InfoContext(ctx, "Verify", slog.String("dir", dir), slog.Int("files", len(targets)), slog.Int("archived", len(entries)-len(targets)))
+
+	failed := 0
+	for _, e := range targets {
+		if err := appctx.WaitResume(ctx); err != nil {
+			return err
+		}
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+
+		err := u.downloader.VerifyFile(ctx, dir, e)
+		if err != nil {
+			failed++
+			slog.WarnContext(ctx, "Verify failed", slog.String("filename", e.Filename), slog.String("error", err.Error()))
+		}
+		if okProgress {
+			state := appctx.FileDone
+			if err != nil {
+				state = appctx.FileFailed
+			}
+			p.UpdateFile(e.Filename, state, e.Filename, err)
+			p.Track(e.Filename, e.FileSize, e.FileSize, true)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files: %w", failed, ErrVerifyFailed)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	BandwidthLimiter struct {
+		mu      sync.Mutex
+		limit   func() int64
+		current int64
+		checked time.Time
+		bucket  *tokenBucket
+	}
+	throttleTransport struct {
+		Transport http.RoundTripper
+		limiter   *BandwidthLimiter
+	}
+	throttledBody struct {
+		ctx     context.Context
+		body    io.ReadCloser
+		limiter *BandwidthLimiter
+	}
+)
+
+var (
+	sharedBandwidthLimiter     *BandwidthLimiter
+	sharedBandwidthLimiterOnce sync.Once
+)
+
+func SharedBandwidthLimiter(limit func() int64) *BandwidthLimiter {
+	sharedBandwidthLimiterOnce.Do(func() {
+		sharedBandwidthLimiter = NewBandwidthLimiter(limit)
+	})
+	return sharedBandwidthLimiter
+}
+
+// limitは毎秒評価されるので、設定やスケジュールの変更が実行中に反映される
+func NewBandwidthLimiter(limit func() int64) *BandwidthLimiter {
+	return &BandwidthLimiter{limit: limit}
+}
+
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	bucket := l.refresh()
+	if bucket == nil {
+		return nil
+	}
+	return bucket.waitN(ctx, float64(n))
+}
+
+func (l *BandwidthLimiter) refresh() *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.checked) < time.Second {
+		return l.bucket
+	}
+	l.checked = now
+
+	limit := l.limit()
+	if limit == l.current {
+		return l.bucket
+	}
+
+	slog.Info("Bandwidth", slog.Int64("bytesPerSecond", limit))
+	l.current = limit
+	if limit <= 0 {
+		l.bucket = nil
+		return nil
+	}
+	l.bucket = &tokenBucket{
+		limit:  RateLimit{PerSecond: float64(limit), Burst: int(limit)},
+		tokens: float64(limit),
+		last:   now,
+	}
+	return l.bucket
+}
+
+func NewThrottleTransport(child http.RoundTripper, limiter *BandwidthLimiter) http.RoundTripper {
+	return &throttleTransport{
+		Transport: child,
+		limiter:   limiter,
+	}
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Body = &throttledBody{ctx: req.Context(), body: resp.Body, limiter: t.limiter}
+	return resp, nil
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if werr := b.limiter.WaitN(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// ProxyUrlはhttp://, https://, socks5://に対応、空なら環境変数を使う
+type TransportOptions struct {
+	ProxyUrl           string
+	CABundle           string
+	InsecureSkipVerify bool
+	MinTLSVersion      string
+}
+
+func NewHttpTransport(opts TransportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(opts.ProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		switch proxyUrl.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxyUrl.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	switch opts.MinTLSVersion {
+	case "":
+	case "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported tls version %q", opts.MinTLSVersion)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
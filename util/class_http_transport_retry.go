@@ -0,0 +1,206 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type EndpointClass string
+
+const (
+	EndpointAuth     EndpointClass = "auth"
+	EndpointQuery    EndpointClass = "query"
+	EndpointDownload EndpointClass = "download"
+
+	endpointKey contextKey = "endpoint"
+)
+
+type (
+	RetryPolicy struct {
+		Attempts  int
+		BaseDelay time.Duration
+		MaxDelay  time.Duration
+	}
+	retryTransport struct {
+		Transport    http.RoundTripper
+		policies     map[EndpointClass]RetryPolicy
+		defaultClass EndpointClass
+	}
+)
+
+// contextでEndpointClassが指定されていなければdefaultClassのポリシーを使う
+func NewRetryTransport(child http.RoundTripper, policies map[EndpointClass]RetryPolicy, defaultClass EndpointClass) http.RoundTripper {
+	return &retryTransport{
+		Transport:    child,
+		policies:     policies,
+		defaultClass: defaultClass,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	class := Endpoint(ctx, t.defaultClass)
+	policy := t.policies[class]
+	if policy.Attempts <= 1 {
+		return t.Transport.RoundTrip(req)
+	}
+
+	getBody, err := replayableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		attempt := req
+		if i > 0 && getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt = req.Clone(ctx)
+			attempt.Body = body
+		}
+
+		resp, err := t.Transport.RoundTrip(attempt)
+		if i == policy.Attempts-1 || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		delay := policy.backoff(i)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header); ok {
+				// 指定された待ち時間が長すぎる場合は呼び出し元に返す
+				if policy.MaxDelay > 0 && after > policy.MaxDelay {
+					return resp, nil
+				}
+				delay = max(delay, after)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+
+		slog.WarnContext(ctx, "Retry",
+			slog.String("class", string(class)),
+			slog.String("url", req.URL.String()),
+			slog.Int("attempt", i+1),
+			slog.Duration("delay", delay),
+			slog.Any("status", statusOf(resp)),
+			slog.Any("error", err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 指数バックオフ、半分をジッターにする
+func (p RetryPolicy) backoff(i int) time.Duration {
+	d := p.BaseDelay << i
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func replayableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+
+	byts, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(byts))
+
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(byts)), nil
+	}, nil
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	return RetryableStatus(resp.StatusCode)
+}
+
+func RetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// 通信エラーと429/5xxはRetryTransportで再試行済みなので、呼び出し側で重ねて再試行しない
+func RetriedByTransport(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+
+	var httpErr *HttpError
+	return errors.As(err, &httpErr) && RetryableStatus(httpErr.StatusCode)
+}
+
+func retryAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(sec)*time.Second, 0), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func WithEndpoint(ctx context.Context, class EndpointClass) context.Context {
+	return context.WithValue(ctx, endpointKey, class)
+}
+
+func Endpoint(ctx context.Context, fallback EndpointClass) EndpointClass {
+	class, ok := ctx.Value(endpointKey).(EndpointClass)
+	if !ok {
+		return fallback
+	}
+
+	return class
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	RateLimit struct {
+		PerSecond float64
+		Burst     int
+	}
+	tokenBucket struct {
+		mu     sync.Mutex
+		limit  RateLimit
+		tokens float64
+		last   time.Time
+	}
+	RateLimiters struct {
+		buckets map[EndpointClass]*tokenBucket
+	}
+	rateLimitTransport struct {
+		Transport    http.RoundTripper
+		limiters     *RateLimiters
+		defaultClass EndpointClass
+	}
+)
+
+var (
+	sharedRateLimiters     *RateLimiters
+	sharedRateLimitersOnce sync.Once
+)
+
+// 最初に呼ばれた時の設定で全クライアント共通のリミッターを作る
+func SharedRateLimiters(limits func() map[EndpointClass]RateLimit) *RateLimiters {
+	sharedRateLimitersOnce.Do(func() {
+		sharedRateLimiters = NewRateLimiters(limits())
+	})
+	return sharedRateLimiters
+}
+
+func NewRateLimiters(limits map[EndpointClass]RateLimit) *RateLimiters {
+	l := &RateLimiters{buckets: map[EndpointClass]*tokenBucket{}}
+	for class, limit := range limits {
+		slog.Info("RateLimit",
+			slog.String("class", string(class)),
+			slog.Float64("perSecond", limit.PerSecond),
+			slog.Int("burst", limit.Burst))
+		if limit.PerSecond <= 0 {
+			continue
+		}
+		limit.Burst = max(limit.Burst, 1)
+		l.buckets[class] = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+	}
+	return l
+}
+
+func (l *RateLimiters) Wait(ctx context.Context, class EndpointClass) error {
+	b, ok := l.buckets[class]
+	if !ok {
+		return nil
+	}
+	return b.wait(ctx)
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	return b.waitN(ctx, 1)
+}
+
+func (b *tokenBucket) waitN(ctx context.Context, n float64) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.PerSecond, float64(b.limit.Burst))
+	b.last = now
+	// 先にトークンを借りて、足りない分だけ待つ
+	b.tokens -= n
+	delay := time.Duration(0)
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.limit.PerSecond * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += n
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func NewRateLimitTransport(child http.RoundTripper, limiters *RateLimiters, defaultClass EndpointClass) http.RoundTripper {
+	return &rateLimitTransport{
+		Transport:    child,
+		limiters:     limiters,
+		defaultClass: defaultClass,
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	class := Endpoint(ctx, t.defaultClass)
+	start := time.Now()
+	if err := t.limiters.Wait(ctx, class); err != nil {
+		return nil, err
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		slog.DebugContext(ctx, "RateLimited", slog.String("class", string(class)), slog.Duration("wait", waited))
+	}
+
+	return t.Transport.RoundTrip(req)
+}
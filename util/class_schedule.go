@@ -0,0 +1,187 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	Schedule interface {
+		// afterより後の次の実行時刻
+		Next(after time.Time) time.Time
+	}
+	intervalSchedule time.Duration
+	// 各フィールドは許可する値のビット集合
+	cronSchedule struct {
+		minute, hour, dom, month, dow uint64
+		// 日と曜日の両方が指定された場合はどちらかに合えば実行する
+		// 一般的なcronと同じく"*"で始まるもの("*/1"なども)は指定なしとして扱う
+		domAny, dowAny bool
+	}
+	cronField struct {
+		min, max int
+	}
+)
+
+var (
+	cronFields = []cronField{
+		{0, 59}, // 分
+		{0, 23}, // 時
+		{1, 31}, // 日
+		{1, 12}, // 月
+		{0, 7},  // 曜日、0と7は日曜
+	}
+	// これより先に実行時刻が見つからない式は無いものとする
+	cronSearchLimit = 5 * 366 * 24 * time.Hour
+)
+
+// intervalは"6h"のようなtime.Duration、cronは"分 時 日 月 曜日"の5フィールド
+func ParseSchedule(interval, cron string) (Schedule, error) {
+	switch {
+	case interval != "" && cron != "":
+		return nil, fmt.Errorf("interval and cron cannot be used together")
+	case interval != "":
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", interval, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval %q is shorter than a minute", interval)
+		}
+		return intervalSchedule(d), nil
+	case cron != "":
+		return parseCron(cron)
+	}
+
+	return nil, fmt.Errorf("interval or cron is required")
+}
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron %q: want %d fields", expr, len(cronFields))
+	}
+
+	bits := make([]uint64, len(cronFields))
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q: %w", expr, err)
+		}
+		bits[i] = b
+	}
+	// 7の日曜は0にまとめる
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// "*", "5", "1-5", "*/15", "1-30/2", "1,15"の組み合わせ
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step %q", item)
+			}
+			step = s
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			v, err := strconv.Atoi(start)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			lo, hi = v, v
+			if isRange {
+				if hi, err = strconv.Atoi(end); err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", item, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// ctxが終わるまでscheduleの時刻ごとにfnを呼ぶ、前の実行が終わるまで次は始めない
+func RunSchedule(ctx context.Context, schedule Schedule, fn func(ctx context.Context)) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		fn(ctx)
+	}
+}
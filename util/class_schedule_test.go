@@ -0,0 +1,103 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2025-01-01は水曜日
+	after := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		cron string
+		want time.Time
+	}{
+		{name: "every minute", cron: "* * * * *", want: time.Date(2025, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{name: "fixed time", cron: "0 3 * * *", want: time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)},
+		{name: "step", cron: "*/15 * * * *", want: time.Date(2025, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{name: "range with step", cron: "0 1-23/6 * * *", want: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{name: "value with step", cron: "40/10 * * * *", want: time.Date(2025, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{name: "list", cron: "0 9,12 * * *", want: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "list and range", cron: "0 1,20-22 * * *", want: time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)},
+		{name: "month", cron: "0 0 1 3 *", want: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "sunday as 0", cron: "0 0 * * 0", want: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{name: "sunday as 7", cron: "0 0 * * 7", want: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{name: "weekdays", cron: "0 0 * * 1-5", want: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// 日と曜日の両方を指定した場合はどちらかに合えばよい
+		{name: "dom or dow", cron: "0 0 15 * 5", want: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{name: "dom or dow dom first", cron: "0 0 2 * 0", want: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// 片方が"*"で始まる場合は両方に合う必要がある
+		{name: "dom with any dow", cron: "0 0 15 * *", want: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "dow with any dom", cron: "0 0 * * 5", want: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{name: "dow with step dom", cron: "0 0 */1 * 5", want: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{name: "dom with step dow", cron: "0 0 15 * */1", want: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "leap day", cron: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule("", tt.cron)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tt.cron, err)
+			}
+			if got := s.Next(after); !got.Equal(tt.want) {
+				t.Errorf("Next(%q) = %v, want %v", tt.cron, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNever(t *testing.T) {
+	s, err := ParseSchedule("", "0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %v, want zero", got)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		cron     string
+		wantErr  bool
+	}{
+		{name: "interval", interval: "6h"},
+		{name: "cron", cron: "0 3 * * *"},
+		{name: "both", interval: "6h", cron: "0 3 * * *", wantErr: true},
+		{name: "neither", wantErr: true},
+		{name: "interval too short", interval: "30s", wantErr: true},
+		{name: "invalid interval", interval: "daily", wantErr: true},
+		{name: "too few fields", cron: "0 3 * *", wantErr: true},
+		{name: "minute out of range", cron: "60 * * * *", wantErr: true},
+		{name: "dom out of range", cron: "0 0 0 * *", wantErr: true},
+		{name: "dow out of range", cron: "0 0 * * 8", wantErr: true},
+		{name: "reversed range", cron: "0 5-1 * * *", wantErr: true},
+		{name: "zero step", cron: "*/0 * * * *", wantErr: true},
+		{name: "not a number", cron: "a * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(tt.interval, tt.cron)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule(%q, %q) error = %v, wantErr %v", tt.interval, tt.cron, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntervalNext(t *testing.T) {
+	s, err := ParseSchedule("6h", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+	if got, want := s.Next(after), after.Add(6*time.Hour); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
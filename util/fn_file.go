@@ -0,0 +1,32 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// 同じディレクトリの一時ファイルに書いてから置き換える、途中で落ちても壊れたファイルを残さない
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
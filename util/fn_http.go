@@ -18,6 +18,16 @@ import (
 
 const UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36"
 
+type HttpError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
 func MustParseUrl(urlString string, queries map[string]string) string {
 	u, err := url.Parse(urlString)
 	if err != nil {
@@ -66,7 +76,7 @@ func HttpDoJSON[T any](client *http.Client, req *http.Request) (*T, error) {
 
 	if !HttpCheck2XX(resp) {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+		return nil, &HttpError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
 	}
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -90,21 +100,24 @@ func HttpDoGzipJSON[T any](client *http.Client, req *http.Request) (*T, error) {
 
 	if !HttpCheck2XX(resp) {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+		return nil, &HttpError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
 	}
 
-	encoding := resp.Header.Get("Content-Encoding")
-	if encoding != "gzip" {
+	var reader io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to new reader: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "":
+	default:
 		return nil, errors.New("missing encoding type")
 	}
 
-	gzipReader, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to new reader: %w", err)
-	}
-	defer gzipReader.Close()
-
-	body, err := io.ReadAll(gzipReader)
+	body, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to ReadAll: %w", err)
 	}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// OSの通知に出す、通知の仕組みが無い環境では何もしない
+func Notify(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	case "linux":
+		path, err := exec.LookPath("notify-send")
+		if err != nil {
+			return nil
+		}
+		cmd = exec.Command(path, title, message)
+	default:
+		return nil
+	}
+
+	return cmd.Run()
+}
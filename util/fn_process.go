@@ -0,0 +1,27 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// pidのプロセスが動いているか、別のユーザーのプロセスも動いているものとする
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+
+	// windowsはFindProcessが成功すればプロセスがある
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+func Retry(ctx context.Context, attempts int, backoff time.Duration, retryable func(error) bool, fn func() error) error {
+	var err error
+	for i := range attempts {
+		if err = fn(); err == nil || !retryable(err) || i == attempts-1 {
+			return err
+		}
+
+		t := time.NewTimer(backoff << i)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return err
+}